@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"xtunnel/control"
+	"xtunnel/service"
+)
+
+var errUsage = errors.New("usage error")
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *CLI, args []string) error
+}
+
+var commands = map[string]command{
+	"list":   {"list [--json]", runList},
+	"add":    {"add --name NAME --server HOST[:PORT] --user USER [options]", runAdd},
+	"edit":   {"edit NAME [options]", runEdit},
+	"rm":     {"rm NAME", runRemove},
+	"start":  {"start NAME...", runStart},
+	"stop":   {"stop NAME...", runStop},
+	"status": {"status [--json] [NAME...]", runStatus},
+	"events": {"events [--json]", runEvents},
+	"export": {"export FILE.yaml|FILE.toml", runExport},
+}
+
+// CLI manages the tunnels of the config store from the command line, tunnels are referenced by name or identifier
+type CLI struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func New() *CLI {
+	return &CLI{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+}
+
+// Run executes the subcommand in args[0], usage errors print the usage of the command
+func (c *CLI) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "help" {
+		c.usage()
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		c.usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	err := cmd.run(ctx, c, args[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(c.stderr, "usage: xtunnel %s\n", cmd.usage)
+	}
+	return err
+}
+
+func (c *CLI) usage() {
+	fmt.Fprintln(c.stderr, "usage: xtunnel [--headless | daemon | COMMAND]")
+	fmt.Fprintln(c.stderr, "\ncommands:")
+	for _, name := range []string{"list", "add", "edit", "rm", "start", "stop", "status", "events", "export"} {
+		fmt.Fprintf(c.stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(c.stderr, "\nrun xtunnel COMMAND -h for the options of a command")
+	fmt.Fprintf(c.stderr, "tunnels are kept in ~/XTunnel/tunnels.yaml when it exists or in the file %s points at\n", service.ConfigStoreEnv)
+	fmt.Fprintf(c.stderr, "stored credentials are encrypted with a master passphrase, %s skips its prompt\n", service.MasterPassphraseEnv)
+}
+
+func (c *CLI) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	return flags
+}
+
+// parse accepts flags before and after the positional arguments, like "rm NAME --json"
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errUsage
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func (c *CLI) printJSON(v any) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *CLI) printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// loadConfigs warns about corrupt config files and goes on with the readable ones
+func (c *CLI) loadConfigs(ctx context.Context) ([]*service.ConfigFile, error) {
+	configs, err := service.DefaultConfigStore().Load(ctx)
+
+	var loadErr *service.ConfigLoadError
+	if errors.As(err, &loadErr) {
+		for _, file := range loadErr.Files {
+			fmt.Fprintf(c.stderr, "xtunnel: warning: %s\n", file)
+		}
+		return configs, nil
+	}
+	return configs, err
+}
+
+// notify lets the running instance pick up a changed config store, it is fine when there is none
+func (c *CLI) notify(ctx context.Context) {
+	client, err := control.Dial(ctx)
+	if err != nil {
+		return
+	}
+	if err := client.Reload(ctx); err != nil {
+		fmt.Fprintf(c.stderr, "xtunnel: reload running instance error: %s\n", err)
+	}
+}
+
+func findAll(configs []*service.ConfigFile, refs []string) ([]*service.ConfigFile, error) {
+	found := make([]*service.ConfigFile, 0, len(refs))
+	for _, ref := range refs {
+		config, err := service.FindConfig(configs, ref)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, config)
+	}
+	return found, nil
+}
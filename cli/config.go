@@ -0,0 +1,433 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"xtunnel/service"
+)
+
+type tunnelInfo struct {
+	Identifier string        `json:"identifier"`
+	Name       string        `json:"name"`
+	Server     string        `json:"server"`
+	User       string        `json:"user"`
+	AuthType   string        `json:"auth_type"`
+	JumpHosts  []string      `json:"jump_hosts"`
+	Forwards   []forwardInfo `json:"forwards"`
+	AutoStart  bool          `json:"autostart"`
+}
+
+// forwardInfo keeps the addresses as ssh -L/-R/-D read, Remote is where a remote rule listens
+type forwardInfo struct {
+	Mode   string `json:"mode"`
+	Local  string `json:"local"`
+	Remote string `json:"remote,omitempty"`
+}
+
+func newTunnelInfo(config *service.ConfigFile) tunnelInfo {
+	info := tunnelInfo{
+		Identifier: config.Identifier,
+		Name:       config.ConfigName,
+		Server:     net.JoinHostPort(config.ServerIP, config.ServerPort),
+		User:       config.UserName,
+		AuthType:   config.AuthType,
+		JumpHosts:  make([]string, 0, len(config.JumpHosts)),
+		AutoStart:  config.AutoStart,
+	}
+
+	for _, hop := range config.JumpHosts {
+		info.JumpHosts = append(info.JumpHosts, fmt.Sprintf("%s@%s", hop.UserName, net.JoinHostPort(hop.IP, hop.Port)))
+	}
+
+	for _, forward := range config.AllForwards() {
+		mode := forward.Mode
+		if mode == "" {
+			mode = service.ForwardModeLocal
+		}
+		info.Forwards = append(info.Forwards, forwardInfo{Mode: mode, Local: forward.LocalAddr(), Remote: forward.RemoteAddr()})
+	}
+
+	return info
+}
+
+func (f forwardInfo) String() string {
+	switch f.Mode {
+	case service.ForwardModeDynamic:
+		return fmt.Sprintf("dynamic %s", f.Local)
+	case service.ForwardModeRemote:
+		return fmt.Sprintf("remote %s -> %s", f.Remote, f.Local)
+	default:
+		return fmt.Sprintf("local %s -> %s", f.Local, f.Remote)
+	}
+}
+
+func runList(ctx context.Context, c *CLI, args []string) error {
+	flags := c.flagSet("list")
+	asJSON := flags.Bool("json", false, "print json instead of a table")
+	if _, err := parse(flags, args); err != nil {
+		return err
+	}
+
+	configs, err := c.loadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	infos := make([]tunnelInfo, 0, len(configs))
+	for _, config := range configs {
+		infos = append(infos, newTunnelInfo(config))
+	}
+
+	if *asJSON {
+		return c.printJSON(infos)
+	}
+
+	rows := make([][]string, 0, len(infos))
+	for _, info := range infos {
+		forwards := make([]string, 0, len(info.Forwards))
+		for _, forward := range info.Forwards {
+			forwards = append(forwards, forward.String())
+		}
+		rows = append(rows, []string{info.Name, info.Identifier, fmt.Sprintf("%s@%s", info.User, info.Server), strings.Join(forwards, ", "), strconv.FormatBool(info.AutoStart)})
+	}
+	return c.printTable([]string{"NAME", "ID", "SERVER", "FORWARDS", "AUTOSTART"}, rows)
+}
+
+// configFlags are shared by add and edit, edit only applies the flags that were given
+type configFlags struct {
+	flags  *flag.FlagSet
+	asJSON bool
+
+	name          string
+	server        string
+	user          string
+	auth          string
+	password      string
+	passwordStdin bool
+	key           string
+	passphrase    string
+	agentKey      string
+	mode          string
+	local         string
+	remote        string
+	socksUser     string
+	socksPassword string
+	keepAlive     string
+	maxMissed     string
+	reconnect     string
+	autoStart     bool
+	forwards      []string
+}
+
+func (c *CLI) configFlags(name string) *configFlags {
+	cf := &configFlags{flags: c.flagSet(name)}
+	flags := cf.flags
+
+	flags.BoolVar(&cf.asJSON, "json", false, "print the saved tunnel as json")
+	flags.StringVar(&cf.name, "name", "", "tunnel name")
+	flags.StringVar(&cf.server, "server", "", "ssh server as HOST[:PORT], the port defaults to 22")
+	flags.StringVar(&cf.user, "user", "", "ssh user name")
+	flags.StringVar(&cf.auth, "auth", "", "authentication: password, key, key_password or agent (default password)")
+	flags.StringVar(&cf.password, "password", "", "ssh password or where to read it, env:NAME, cmd:COMMAND or file:PATH, prefer --password-stdin for a plain one")
+	flags.BoolVar(&cf.passwordStdin, "password-stdin", false, "read the ssh password from the first line of stdin")
+	flags.StringVar(&cf.key, "key", "", "private key file")
+	flags.StringVar(&cf.passphrase, "passphrase", "", "private key passphrase, env:NAME, cmd:COMMAND and file:PATH read it from there")
+	flags.StringVar(&cf.agentKey, "agent-key", "", "SHA256 fingerprint of the agent key to use, any agent key when empty")
+	flags.StringVar(&cf.mode, "mode", "", "forward mode: local, remote or dynamic (default local)")
+	flags.StringVar(&cf.local, "local", "", "local address as [HOST:]PORT, the dial target in remote mode")
+	flags.StringVar(&cf.remote, "remote", "", "remote address as HOST:PORT, the server side listen address in remote mode")
+	flags.StringVar(&cf.socksUser, "socks-user", "", "socks5 user name in dynamic mode")
+	flags.StringVar(&cf.socksPassword, "socks-password", "", "socks5 password in dynamic mode, env:NAME, cmd:COMMAND and file:PATH read it from there")
+	flags.StringVar(&cf.keepAlive, "keepalive", "", "keepalive interval in seconds")
+	flags.StringVar(&cf.maxMissed, "keepalive-max-missed", "", "keepalives missed before the connection counts as lost")
+	flags.StringVar(&cf.reconnect, "reconnect", "", "reconnect attempts after the connection is lost, 0 disables reconnecting")
+	flags.BoolVar(&cf.autoStart, "autostart", false, "start the tunnel when xtunnel comes up")
+	flags.Func("forward", "extra rule as MODE,LOCAL[,REMOTE], repeatable, replaces the extra rules on edit", func(value string) error {
+		cf.forwards = append(cf.forwards, value)
+		return nil
+	})
+
+	return cf
+}
+
+// apply copies the given flags onto config and drops the settings the chosen auth type and mode do not use
+func (cf *configFlags) apply(c *CLI, config *service.ConfigFile) error {
+	var err error
+	cf.flags.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		switch f.Name {
+		case "name":
+			config.ConfigName = cf.name
+		case "server":
+			config.ServerIP, config.ServerPort, err = splitAddr(cf.server, "22")
+		case "user":
+			config.UserName = cf.user
+		case "auth":
+			config.AuthType = cf.auth
+		case "password":
+			config.Password = cf.password
+		case "password-stdin":
+			config.Password, err = c.readLine()
+		case "key":
+			config.PrivateKeyPath = cf.key
+			config.PrivateKey = ""
+		case "passphrase":
+			config.Passphrase = cf.passphrase
+		case "agent-key":
+			config.AgentFingerprint = cf.agentKey
+		case "mode":
+			config.Mode = cf.mode
+		case "local":
+			config.LocalIP, config.LocalPort, err = splitAddr(cf.local, "")
+		case "remote":
+			config.RemoteIP, config.RemotePort, err = splitAddr(cf.remote, "")
+		case "socks-user":
+			config.SocksUsername = cf.socksUser
+		case "socks-password":
+			config.SocksPassword = cf.socksPassword
+		case "keepalive":
+			config.KeepAliveInterval = cf.keepAlive
+		case "keepalive-max-missed":
+			config.KeepAliveMaxMissed = cf.maxMissed
+		case "reconnect":
+			config.ReconnectAttempts = cf.reconnect
+		case "autostart":
+			config.AutoStart = cf.autoStart
+		case "forward":
+			config.Forwards, err = parseForwards(cf.forwards)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if config.AuthType == "" {
+		config.AuthType = service.AuthTypePassword
+	}
+	if !service.UsesPassword(config.AuthType) {
+		config.Password = ""
+	}
+	if !service.UsesPrivateKey(config.AuthType) {
+		config.PrivateKeyPath, config.PrivateKey, config.Passphrase = "", "", ""
+	}
+	if config.AuthType != service.AuthTypeAgent {
+		config.AgentFingerprint = ""
+	}
+
+	if config.Mode == "" {
+		config.Mode = service.ForwardModeLocal
+	}
+	if config.Mode == service.ForwardModeDynamic {
+		config.RemoteIP, config.RemotePort = "", ""
+	} else {
+		config.SocksUsername, config.SocksPassword = "", ""
+	}
+
+	return nil
+}
+
+func (c *CLI) readLine() (string, error) {
+	line, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password from stdin error: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// splitAddr accepts a bare port or host, the missing part is left empty or takes defaultPort
+func splitAddr(addr, defaultPort string) (string, string, error) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		return host, port, nil
+	}
+
+	if _, err := strconv.Atoi(addr); err == nil && defaultPort == "" {
+		return "", addr, nil
+	}
+
+	if strings.Contains(strings.Trim(addr, "[]"), ":") && net.ParseIP(strings.Trim(addr, "[]")) == nil {
+		return "", "", fmt.Errorf("invalid address %q", addr)
+	}
+	return strings.Trim(addr, "[]"), defaultPort, nil
+}
+
+func parseForwards(specs []string) ([]*service.ForwardConfig, error) {
+	forwards := make([]*service.ForwardConfig, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ",")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid forward %q, expected MODE,LOCAL[,REMOTE]", spec)
+		}
+
+		forward := &service.ForwardConfig{Mode: parts[0]}
+		var err error
+		if forward.LocalIP, forward.LocalPort, err = splitAddr(parts[1], ""); err != nil {
+			return nil, err
+		}
+		if len(parts) == 3 {
+			if forward.RemoteIP, forward.RemotePort, err = splitAddr(parts[2], ""); err != nil {
+				return nil, err
+			}
+		}
+		forwards = append(forwards, forward)
+	}
+	return forwards, nil
+}
+
+func runAdd(ctx context.Context, c *CLI, args []string) error {
+	cf := c.configFlags("add")
+	if positional, err := parse(cf.flags, args); err != nil {
+		return err
+	} else if len(positional) > 0 {
+		return errUsage
+	}
+
+	config := &service.ConfigFile{}
+	if err := cf.apply(c, config); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.HasPlaintextSecrets() {
+		if err := c.unlockSecrets(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := service.DefaultConfigStore().Create(ctx, config); err != nil {
+		return err
+	}
+	c.notify(ctx)
+
+	return c.printSaved(config, cf.asJSON)
+}
+
+func runEdit(ctx context.Context, c *CLI, args []string) error {
+	cf := c.configFlags("edit")
+	positional, err := parse(cf.flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	configs, err := c.loadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := service.FindConfig(configs, positional[0])
+	if err != nil {
+		return err
+	}
+
+	if err := cf.apply(c, config); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.HasPlaintextSecrets() {
+		if err := c.unlockSecrets(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := service.DefaultConfigStore().Update(ctx, config); err != nil {
+		return err
+	}
+	c.notify(ctx)
+
+	return c.printSaved(config, cf.asJSON)
+}
+
+func (c *CLI) printSaved(config *service.ConfigFile, asJSON bool) error {
+	if asJSON {
+		return c.printJSON(newTunnelInfo(config))
+	}
+
+	_, err := fmt.Fprintf(c.stdout, "saved %s (%s)\n", config.ConfigName, config.Identifier)
+	return err
+}
+
+func runRemove(ctx context.Context, c *CLI, args []string) error {
+	positional, err := parse(c.flagSet("rm"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	configs, err := c.loadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := service.FindConfig(configs, positional[0])
+	if err != nil {
+		return err
+	}
+
+	if err := service.DefaultConfigStore().Delete(ctx, config); err != nil {
+		return err
+	}
+	c.notify(ctx)
+
+	_, err = fmt.Fprintf(c.stdout, "removed %s (%s)\n", config.ConfigName, config.Identifier)
+	return err
+}
+
+// runExport copies the tunnels into a single file store, the stored credentials are copied along so
+// removing a tunnel from one store leaves them to the other
+func runExport(ctx context.Context, c *CLI, args []string) error {
+	positional, err := parse(c.flagSet("export"), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	path, err := filepath.Abs(positional[0])
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	configs, err := c.loadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(configs, (*service.ConfigFile).HasPlaintextSecrets) || slices.ContainsFunc(configs, (*service.ConfigFile).HasSecretRefs) {
+		if err := c.unlockSecrets(ctx); err != nil {
+			return err
+		}
+	}
+
+	store := service.OpenFileConfigStore(path)
+	for _, config := range configs {
+		config.FileName = ""
+		if err := config.CopySecrets(ctx); err != nil {
+			return err
+		}
+		if err := store.Create(ctx, config); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(c.stdout, "exported %d tunnels to %s, it is used when it is ~/XTunnel/tunnels.yaml or %s points at it\n", len(configs), path, service.ConfigStoreEnv)
+	return err
+}
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xtunnel/service"
+)
+
+func applyFlags(t *testing.T, name string, config *service.ConfigFile, args ...string) error {
+	t.Helper()
+
+	c := &CLI{stdin: strings.NewReader(""), stdout: io.Discard, stderr: io.Discard}
+	cf := c.configFlags(name)
+	if _, err := parse(cf.flags, args); err != nil {
+		t.Fatalf("parse %v: %s", args, err)
+	}
+	if err := cf.apply(c, config); err != nil {
+		return err
+	}
+	return config.Validate()
+}
+
+func TestAddKeyPassword(t *testing.T) {
+	config := &service.ConfigFile{}
+	err := applyFlags(t, "add", config,
+		"--name", "db", "--server", "bastion", "--user", "u", "--auth", "key_password",
+		"--password", "env:DB_PASSWORD", "--key", "/home/u/.ssh/id_ed25519", "--passphrase", "env:DB_PASSPHRASE",
+		"--remote", "10.0.0.5:5432")
+	if err != nil {
+		t.Fatalf("add --auth key_password: %s", err)
+	}
+
+	if config.AuthType != service.AuthTypeKeyPassword {
+		t.Errorf("auth type = %q", config.AuthType)
+	}
+	if config.Password != "env:DB_PASSWORD" {
+		t.Errorf("password = %q, it must be kept for key_password", config.Password)
+	}
+	if config.PrivateKeyPath != "/home/u/.ssh/id_ed25519" || config.Passphrase != "env:DB_PASSPHRASE" {
+		t.Errorf("key = %q, passphrase = %q, both must be kept for key_password", config.PrivateKeyPath, config.Passphrase)
+	}
+}
+
+func TestAddKeyPasswordNeedsBoth(t *testing.T) {
+	base := []string{"--name", "db", "--server", "bastion", "--user", "u", "--auth", "key_password", "--remote", "10.0.0.5:5432"}
+
+	if err := applyFlags(t, "add", &service.ConfigFile{}, append(base, "--key", "/k")...); err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("missing password: err = %v", err)
+	}
+	if err := applyFlags(t, "add", &service.ConfigFile{}, append(base, "--password", "env:P")...); err == nil || !strings.Contains(err.Error(), "private key") {
+		t.Errorf("missing key: err = %v", err)
+	}
+}
+
+func TestEditKeyPasswordKeepsCredentials(t *testing.T) {
+	config := &service.ConfigFile{
+		ConfigName:     "a",
+		ServerIP:       "bastion",
+		ServerPort:     "22",
+		UserName:       "u",
+		AuthType:       service.AuthTypeKeyPassword,
+		Password:       "secret:0011223344556677",
+		PrivateKeyPath: "/k",
+		Passphrase:     "secret:8899aabbccddeeff",
+		Mode:           service.ForwardModeLocal,
+		RemoteIP:       "10.0.0.5",
+		RemotePort:     "5432",
+	}
+
+	if err := applyFlags(t, "edit", config, "--name", "b"); err != nil {
+		t.Fatalf("edit --name: %s", err)
+	}
+	if config.ConfigName != "b" {
+		t.Errorf("name = %q", config.ConfigName)
+	}
+	if config.Password != "secret:0011223344556677" || config.PrivateKeyPath != "/k" || config.Passphrase != "secret:8899aabbccddeeff" {
+		t.Errorf("edit dropped credentials: password = %q, key = %q, passphrase = %q", config.Password, config.PrivateKeyPath, config.Passphrase)
+	}
+}
+
+func TestSwitchAuthDropsUnusedCredentials(t *testing.T) {
+	config := &service.ConfigFile{
+		ConfigName:     "a",
+		ServerIP:       "bastion",
+		ServerPort:     "22",
+		UserName:       "u",
+		AuthType:       service.AuthTypeKeyPassword,
+		Password:       "env:P",
+		PrivateKeyPath: "/k",
+		Mode:           service.ForwardModeLocal,
+		RemoteIP:       "10.0.0.5",
+		RemotePort:     "5432",
+	}
+
+	if err := applyFlags(t, "edit", config, "--auth", "key"); err != nil {
+		t.Fatalf("edit --auth key: %s", err)
+	}
+	if config.Password != "" {
+		t.Errorf("password = %q, key auth does not use it", config.Password)
+	}
+	if config.PrivateKeyPath != "/k" {
+		t.Errorf("key = %q", config.PrivateKeyPath)
+	}
+}
+
+// the exported store gets copies of the credentials, deleting the tunnel there keeps the ones of the original
+func TestExportCopiesSecrets(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv(service.MasterPassphraseEnv, "test")
+
+	c := &CLI{stdin: strings.NewReader(""), stdout: io.Discard, stderr: io.Discard}
+	err := c.Run(ctx, []string{"add", "--name", "db", "--server", "bastion", "--user", "u", "--password", "secret-password", "--remote", "10.0.0.5:5432"})
+	if err != nil {
+		t.Fatalf("add: %s", err)
+	}
+	original, err := c.loadConfigs(ctx)
+	if err != nil || len(original) != 1 {
+		t.Fatalf("load: %v, %v", original, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tunnels.yaml")
+	if err := c.Run(ctx, []string{"export", path}); err != nil {
+		t.Fatalf("export: %s", err)
+	}
+	store := service.OpenFileConfigStore(path)
+	exported, err := store.Load(ctx)
+	if err != nil || len(exported) != 1 {
+		t.Fatalf("load export: %v, %v", exported, err)
+	}
+	if exported[0].Password == original[0].Password {
+		t.Fatalf("the export shares the secret ref %s", exported[0].Password)
+	}
+	if password, err := service.Secrets.Get(ctx, exported[0].Password); err != nil || password != "secret-password" {
+		t.Fatalf("exported password = %q, %v", password, err)
+	}
+
+	if err := store.Delete(ctx, exported[0]); err != nil {
+		t.Fatal(err)
+	}
+	// the .bak still holds the deleted tunnel until the next change
+	if err := store.Create(ctx, &service.ConfigFile{ConfigName: "other", ServerIP: "bastion", ServerPort: "22", UserName: "u", AuthType: service.AuthTypeAgent, RemoteIP: "10.0.0.6", RemotePort: "22"}); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := service.Secrets.Get(ctx, original[0].Password); err != nil || password != "secret-password" {
+		t.Fatalf("original password = %q, %v after deleting the export", password, err)
+	}
+}
@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"os"
+	"testing"
+	"xtunnel/logger"
+)
+
+// TestMain points HOME at a temp dir, the commands keep their configs, secrets and logs there
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "xtunnel-cli-test-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("USERPROFILE", home)
+	logger.Init()
+	logger.Logger.SetStdoutPrint(false)
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"xtunnel/service"
+)
+
+// unlockSecrets opens the secret store for commands that store or use credentials,
+// the passphrase comes from XTUNNEL_MASTER_PASSPHRASE or is asked for on the terminal
+func (c *CLI) unlockSecrets(ctx context.Context) error {
+	if !service.Secrets.Locked() {
+		return nil
+	}
+	if err := service.Secrets.UnlockFromEnv(ctx); err != nil || !service.Secrets.Locked() {
+		return err
+	}
+
+	stdin, ok := c.stdin.(*os.File)
+	if !ok || !term.IsTerminal(int(stdin.Fd())) {
+		return fmt.Errorf("%w, set %s", service.ErrSecretStoreLocked, service.MasterPassphraseEnv)
+	}
+
+	if service.Secrets.Exists(ctx) {
+		passphrase, err := c.readPassphrase(stdin, "Master passphrase: ")
+		if err != nil {
+			return err
+		}
+		return service.Secrets.Unlock(ctx, passphrase)
+	}
+
+	fmt.Fprintln(c.stderr, "Credentials are stored encrypted, choose a master passphrase to protect them.")
+	passphrase, err := c.readPassphrase(stdin, "New master passphrase: ")
+	if err != nil {
+		return err
+	}
+	repeat, err := c.readPassphrase(stdin, "Repeat master passphrase: ")
+	if err != nil {
+		return err
+	}
+	if passphrase != repeat {
+		return fmt.Errorf("master passphrases do not match")
+	}
+	return service.Secrets.Unlock(ctx, passphrase)
+}
+
+func (c *CLI) readPassphrase(stdin *os.File, prompt string) (string, error) {
+	fmt.Fprint(c.stderr, prompt)
+	passphrase, err := term.ReadPassword(int(stdin.Fd()))
+	fmt.Fprintln(c.stderr)
+	if err != nil {
+		return "", fmt.Errorf("read passphrase error: %w", err)
+	}
+	return string(passphrase), nil
+}
@@ -0,0 +1,386 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"xtunnel/control"
+	"xtunnel/service"
+)
+
+const (
+	settleTimeout  = 30 * time.Second
+	settleInterval = 200 * time.Millisecond
+)
+
+// runStart hands the tunnels to the running instance, without one they run in the foreground until xtunnel is interrupted
+func runStart(ctx context.Context, c *CLI, args []string) error {
+	flags := c.flagSet("start")
+	asJSON := flags.Bool("json", false, "print json instead of a table")
+	refs, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return errUsage
+	}
+
+	client, err := control.Dial(ctx)
+	if errors.Is(err, control.ErrNoInstance) {
+		// the foreground run is an instance of its own, unless another one just came up
+		lock, err := control.AcquireLock(ctx)
+		if err == nil {
+			defer lock.Release()
+			return c.startForeground(ctx, refs, *asJSON)
+		}
+		if !errors.Is(err, control.ErrLocked) {
+			return err
+		}
+		client, err = control.WaitDial(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if _, err := client.Start(ctx, ref); err != nil {
+			return err
+		}
+	}
+
+	states := make([]control.TunnelState, 0, len(refs))
+	for _, ref := range refs {
+		state, err := settle(ctx, client, ref)
+		if err != nil {
+			return err
+		}
+		states = append(states, state)
+	}
+
+	if err := c.printStates(states, *asJSON); err != nil {
+		return err
+	}
+	for _, state := range states {
+		if state.Status == service.StatusFailed.String() {
+			return fmt.Errorf("tunnel %s failed", state.Name)
+		}
+	}
+	return nil
+}
+
+// settle waits until the tunnel is done starting, so the caller sees whether it came up
+func settle(ctx context.Context, client *control.Client, ref string) (control.TunnelState, error) {
+	ctx, cancel := context.WithTimeout(ctx, settleTimeout)
+	defer cancel()
+
+	for {
+		state, err := client.Tunnel(ctx, ref)
+		if err != nil || state.Status != service.StatusStarting.String() {
+			return state, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, nil
+		case <-time.After(settleInterval):
+		}
+	}
+}
+
+func (c *CLI) startForeground(ctx context.Context, refs []string, asJSON bool) error {
+	configs, err := c.loadConfigs(ctx)
+	if err != nil {
+		return err
+	}
+	selected, err := findAll(configs, refs)
+	if err != nil {
+		return err
+	}
+	for _, config := range selected {
+		if config.HasSecretRefs() {
+			if err := c.unlockSecrets(ctx); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	manager := service.NewTunnelManager()
+	manager.SetHostKeyConfirm(c.confirmHostKey)
+	defer manager.StopAll(ctx)
+
+	var namesMu sync.Mutex
+	names := make(map[string]string, len(selected))
+	for _, config := range selected {
+		names[config.Identifier] = config.ConfigName
+		if _, err := manager.AddTunnel(ctx, config.Identifier, config.TunnelConfig()); err != nil {
+			return err
+		}
+	}
+
+	// other cli calls can start and stop tunnels or follow their events meanwhile, the configs are
+	// reloaded for them like the window does, so a second xtunnel start finds its tunnel here
+	reload := func(ctx context.Context) error {
+		configs, err := service.DefaultConfigStore().Load(ctx)
+		var loadErr *service.ConfigLoadError
+		if err != nil && !errors.As(err, &loadErr) {
+			return err
+		}
+
+		synced := manager.SyncConfigs(ctx, configs)
+		namesMu.Lock()
+		defer namesMu.Unlock()
+		for _, config := range synced {
+			names[config.Identifier] = config.ConfigName
+		}
+		return nil
+	}
+	server := control.NewServer(manager, reload)
+	if err := server.Start(ctx); err != nil {
+		return err
+	}
+	defer server.Stop(ctx)
+
+	events, unsubscribe := manager.Subscribe(64)
+	defer unsubscribe()
+
+	for _, config := range selected {
+		if err := manager.StartTunnel(ctx, config.Identifier); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-events:
+			if event.Type != service.EventStatus {
+				continue
+			}
+
+			namesMu.Lock()
+			name := names[event.Identifier]
+			namesMu.Unlock()
+
+			state := control.TunnelState{Identifier: event.Identifier, Name: name, Status: event.Status.String(), ListenAddrs: []string{}}
+			if addrs, err := manager.ListenAddrs(ctx, event.Identifier); err == nil && addrs != nil && event.Status == service.StatusRunning {
+				state.ListenAddrs = addrs
+			}
+			if event.Failure != nil {
+				state.Reason = string(event.Failure.Reason)
+				state.Error = event.Failure.Err.Error()
+			}
+			if err := c.printChange(event.Time, state, asJSON); err != nil {
+				return err
+			}
+
+			if event.Status == service.StatusFailed && allFailed(ctx, manager) {
+				return fmt.Errorf("every tunnel failed")
+			}
+		}
+	}
+}
+
+// allFailed tells that none of the tunnels started in the foreground is left, the ones never started
+// or stopped through the control api do not count
+func allFailed(ctx context.Context, manager *service.TunnelManager) bool {
+	failed := false
+	for _, identifier := range manager.Identifiers() {
+		status, err := manager.StatusTunnel(ctx, identifier)
+		if err != nil {
+			continue
+		}
+		switch status {
+		case service.StatusFailed:
+			failed = true
+		case service.StatusStopped:
+		default:
+			return false
+		}
+	}
+	return failed
+}
+
+func (c *CLI) printChange(at time.Time, state control.TunnelState, asJSON bool) error {
+	if asJSON {
+		return c.printJSON(state)
+	}
+
+	line := fmt.Sprintf("%s %s: %s", at.Format("15:04:05"), state.Name, state.Status)
+	if len(state.ListenAddrs) > 0 {
+		line += fmt.Sprintf(", listening on %s", strings.Join(state.ListenAddrs, ", "))
+	}
+	if state.Error != "" {
+		line += fmt.Sprintf(", %s", state.Error)
+	}
+	_, err := fmt.Fprintln(c.stdout, line)
+	return err
+}
+
+// confirmHostKey asks on the terminal, a closed stdin rejects the key
+func (c *CLI) confirmHostKey(ctx context.Context, host string, keyType string, fingerprint string) bool {
+	fmt.Fprintf(c.stderr, "The authenticity of host %s can't be established.\n%s key fingerprint is %s.\nTrust this host key (yes/no)? ", host, keyType, fingerprint)
+	answer, err := c.readLine()
+	if err != nil {
+		fmt.Fprintln(c.stderr)
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(answer), "yes")
+}
+
+func runStop(ctx context.Context, c *CLI, args []string) error {
+	flags := c.flagSet("stop")
+	asJSON := flags.Bool("json", false, "print json instead of a table")
+	refs, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return errUsage
+	}
+
+	client, err := control.Dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	states := make([]control.TunnelState, 0, len(refs))
+	for _, ref := range refs {
+		state, err := client.Stop(ctx, ref)
+		if err != nil {
+			return err
+		}
+		states = append(states, state)
+	}
+	return c.printStates(states, *asJSON)
+}
+
+func runStatus(ctx context.Context, c *CLI, args []string) error {
+	flags := c.flagSet("status")
+	asJSON := flags.Bool("json", false, "print json instead of a table")
+	refs, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	client, err := control.Dial(ctx)
+	if errors.Is(err, control.ErrNoInstance) {
+		states, err := c.localStates(ctx, refs)
+		if err != nil {
+			return err
+		}
+		return c.printStates(states, *asJSON)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		states, err := client.Tunnels(ctx)
+		if err != nil {
+			return err
+		}
+		return c.printStates(states, *asJSON)
+	}
+
+	states := make([]control.TunnelState, 0, len(refs))
+	for _, ref := range refs {
+		state, err := client.Tunnel(ctx, ref)
+		if err != nil {
+			return err
+		}
+		states = append(states, state)
+	}
+	return c.printStates(states, *asJSON)
+}
+
+// localStates reports every tunnel as stopped, nothing runs without an instance
+func (c *CLI) localStates(ctx context.Context, refs []string) ([]control.TunnelState, error) {
+	configs, err := c.loadConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) > 0 {
+		if configs, err = findAll(configs, refs); err != nil {
+			return nil, err
+		}
+	}
+
+	manager := service.NewTunnelManager()
+	states := make([]control.TunnelState, 0, len(configs))
+	for _, config := range configs {
+		states = append(states, control.State(ctx, manager, config))
+	}
+	return states, nil
+}
+
+func (c *CLI) printStates(states []control.TunnelState, asJSON bool) error {
+	if asJSON {
+		return c.printJSON(states)
+	}
+
+	rows := make([][]string, 0, len(states))
+	for _, state := range states {
+		rows = append(rows, []string{state.Name, state.Identifier, state.Status, strings.Join(state.ListenAddrs, ", "), state.Error})
+	}
+	return c.printTable([]string{"NAME", "ID", "STATUS", "LISTEN", "ERROR"}, rows)
+}
+
+// runEvents follows the events of the running instance until xtunnel is interrupted
+func runEvents(ctx context.Context, c *CLI, args []string) error {
+	flags := c.flagSet("events")
+	asJSON := flags.Bool("json", false, "print one json object per line")
+	if _, err := parse(flags, args); err != nil {
+		return err
+	}
+
+	client, err := control.Dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, err := client.Events(ctx)
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		if err := c.printEvent(event, *asJSON); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() == nil {
+		return fmt.Errorf("xtunnel instance went away")
+	}
+	return nil
+}
+
+func (c *CLI) printEvent(event control.EventMessage, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(c.stdout).Encode(event)
+	}
+
+	line := fmt.Sprintf("%s %s %s", event.Time.Format("15:04:05"), event.Identifier, event.Type)
+	switch service.EventType(event.Type) {
+	case service.EventStatus:
+		line += " " + event.Status
+	case service.EventConnOpened:
+		line += fmt.Sprintf(" %s -> %s", event.Client, event.Target)
+	case service.EventConnClosed:
+		line += fmt.Sprintf(" %s -> %s, sent %d, received %d", event.Client, event.Target, event.BytesSent, event.BytesReceived)
+	case service.EventTraffic:
+		line += fmt.Sprintf(" sent %d, received %d", event.BytesSent, event.BytesReceived)
+	}
+	if event.Error != "" {
+		line += ", " + event.Error
+	}
+
+	_, err := fmt.Fprintln(c.stdout, line)
+	return err
+}
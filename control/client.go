@@ -0,0 +1,170 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	dialTimeout  = 2 * time.Second
+	waitTimeout  = 5 * time.Second
+	waitInterval = 100 * time.Millisecond
+)
+
+// Client talks to the control socket of the running instance
+type Client struct {
+	http *http.Client
+}
+
+// Dial connects to the running instance, ErrNoInstance tells that nothing listens on the control socket
+func Dial(ctx context.Context) (*Client, error) {
+	path, err := SocketPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		return dialer.DialContext(ctx, "unix", path)
+	}
+
+	conn, err := dial(ctx, "", "")
+	if err != nil {
+		return nil, ErrNoInstance
+	}
+	conn.Close()
+
+	return &Client{
+		http: &http.Client{Transport: &http.Transport{DialContext: dial}},
+	}, nil
+}
+
+// WaitDial retries Dial for a while, the instance holding the lock may still be bringing up its socket
+func WaitDial(ctx context.Context) (*Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+
+	for {
+		client, err := Dial(ctx)
+		if !errors.Is(err, ErrNoInstance) {
+			return client, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+func (c *Client) Tunnels(ctx context.Context) ([]TunnelState, error) {
+	var states []TunnelState
+	return states, c.do(ctx, http.MethodGet, "/v1/tunnels", &states)
+}
+
+func (c *Client) Tunnel(ctx context.Context, ref string) (TunnelState, error) {
+	var state TunnelState
+	return state, c.do(ctx, http.MethodGet, "/v1/tunnels/"+url.PathEscape(ref), &state)
+}
+
+func (c *Client) Start(ctx context.Context, ref string) (TunnelState, error) {
+	var state TunnelState
+	return state, c.do(ctx, http.MethodPost, "/v1/tunnels/"+url.PathEscape(ref)+"/start", &state)
+}
+
+func (c *Client) Stop(ctx context.Context, ref string) (TunnelState, error) {
+	var state TunnelState
+	return state, c.do(ctx, http.MethodPost, "/v1/tunnels/"+url.PathEscape(ref)+"/stop", &state)
+}
+
+// Reload makes the instance pick up configs changed in the store
+func (c *Client) Reload(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/reload", nil)
+}
+
+// Activate tells the running instance that xtunnel was launched again, ErrHeadless tells that it has no window to raise
+func (c *Client) Activate(ctx context.Context) error {
+	err := c.do(ctx, http.MethodPost, "/v1/activate", nil)
+	if err != nil && err.Error() == ErrHeadless.Error() {
+		return ErrHeadless
+	}
+	return err
+}
+
+// Events streams the events of every tunnel, the channel is closed when ctx is done or the instance goes away
+func (c *Client) Events(ctx context.Context) (<-chan EventMessage, error) {
+	response, err := c.request(ctx, http.MethodGet, "/v1/events")
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan EventMessage)
+	go func() {
+		defer close(events)
+		defer response.Body.Close()
+
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			var event EventMessage
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v any) error {
+	response, err := c.request(ctx, method, path)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// request fails with the error message of the instance on non 2xx responses
+func (c *Client) request(ctx context.Context, method, path string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, "http://xtunnel"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("control request error: %w", err)
+	}
+
+	if response.StatusCode/100 != 2 {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+
+		var message errorMessage
+		if json.Unmarshal(body, &message) == nil && message.Error != "" {
+			return nil, errors.New(message.Error)
+		}
+		return nil, fmt.Errorf("control request error: %s %s", response.Status, bytes.TrimSpace(body))
+	}
+
+	return response, nil
+}
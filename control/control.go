@@ -0,0 +1,103 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"os"
+	"path/filepath"
+	"time"
+	"xtunnel/logger"
+	"xtunnel/service"
+)
+
+var (
+	ErrNoInstance = errors.New("no running xtunnel instance")
+	// ErrHeadless is returned by Activate when the running instance is a daemon or a foreground xtunnel start
+	ErrHeadless = errors.New("the running xtunnel instance has no window")
+)
+
+// TunnelState is the status of one tunnel as the api reports it, Reason and Error are set once it failed
+type TunnelState struct {
+	Identifier  string   `json:"identifier"`
+	Name        string   `json:"name"`
+	Status      string   `json:"status"`
+	ListenAddrs []string `json:"listen_addrs"`
+	Reason      string   `json:"reason,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// EventMessage is a service.Event on the wire, the events endpoint streams one per line
+type EventMessage struct {
+	Type          string    `json:"type"`
+	Identifier    string    `json:"identifier"`
+	Time          time.Time `json:"time"`
+	Status        string    `json:"status,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Client        string    `json:"client,omitempty"`
+	Target        string    `json:"target,omitempty"`
+	BytesSent     int64     `json:"bytes_sent,omitempty"`
+	BytesReceived int64     `json:"bytes_received,omitempty"`
+}
+
+type errorMessage struct {
+	Error string `json:"error"`
+}
+
+// State reads the status of a tunnel from manager, a tunnel the manager does not know reports stopped
+func State(ctx context.Context, manager *service.TunnelManager, config *service.ConfigFile) TunnelState {
+	state := TunnelState{Identifier: config.Identifier, Name: config.ConfigName, Status: service.StatusStopped.String(), ListenAddrs: []string{}}
+
+	status, err := manager.StatusTunnel(ctx, config.Identifier)
+	if err != nil {
+		return state
+	}
+	state.Status = status.String()
+
+	if addrs, err := manager.ListenAddrs(ctx, config.Identifier); err == nil && addrs != nil {
+		state.ListenAddrs = addrs
+	}
+	if failure, err := manager.Failure(ctx, config.Identifier); err == nil && failure != nil {
+		state.Reason = string(failure.Reason)
+		state.Error = failure.Err.Error()
+	}
+
+	return state
+}
+
+func newEventMessage(event service.Event) EventMessage {
+	message := EventMessage{
+		Type:          string(event.Type),
+		Identifier:    event.Identifier,
+		Time:          event.Time,
+		Client:        event.Client,
+		Target:        event.Target,
+		BytesSent:     event.BytesSent,
+		BytesReceived: event.BytesReceived,
+	}
+
+	if event.Type == service.EventStatus {
+		message.Status = event.Status.String()
+	}
+	if event.Failure != nil {
+		message.Reason = string(event.Failure.Reason)
+	}
+	if event.Err != nil {
+		message.Error = event.Err.Error()
+	}
+
+	return message
+}
+
+// SocketPath is the per user socket of the running instance, ~/XTunnel/xtunnel.sock
+func SocketPath(ctx context.Context) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logger.Error(ctx, "cannot find home dir", g.Map{"error": err.Error()})
+		return "", fmt.Errorf("cannot find home dir")
+	}
+
+	return filepath.Join(homeDir, "XTunnel", "xtunnel.sock"), nil
+}
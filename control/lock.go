@@ -0,0 +1,76 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"xtunnel/logger"
+)
+
+var ErrLocked = errors.New("xtunnel is already running")
+
+// Lock marks the one running instance of a user, the os drops it with the process so a crash leaves no stale lock behind
+type Lock struct {
+	file *os.File
+}
+
+// AcquireLock takes ~/XTunnel/xtunnel.lock without waiting, ErrLocked tells that another instance holds it
+func AcquireLock(ctx context.Context) (*Lock, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logger.Error(ctx, "cannot find home dir", g.Map{"error": err.Error()})
+		return nil, fmt.Errorf("cannot find home dir")
+	}
+
+	path := filepath.Join(homeDir, "XTunnel", "xtunnel.lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logger.Error(ctx, "instance lock mkdir error", g.Map{"path": path, "error": err.Error()})
+		return nil, fmt.Errorf("instance lock mkdir error")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		logger.Error(ctx, "instance lock open error", g.Map{"path": path, "error": err.Error()})
+		return nil, fmt.Errorf("instance lock open error")
+	}
+
+	if err := lockFile(file); err != nil {
+		pid := readPID(file)
+		file.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, fmt.Errorf("%w with pid %s", ErrLocked, pid)
+		}
+		logger.Error(ctx, "instance lock error", g.Map{"path": path, "error": err.Error()})
+		return nil, fmt.Errorf("instance lock error: %w", err)
+	}
+
+	// the pid is informational, the lock itself is what counts
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+
+	logger.Info(ctx, "instance lock acquired", g.Map{"path": path, "pid": os.Getpid()})
+	return &Lock{file: file}, nil
+}
+
+func (l *Lock) Release() {
+	if l == nil {
+		return
+	}
+	unlockFile(l.file)
+	l.file.Close()
+}
+
+func readPID(file *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := file.ReadAt(buf, 0)
+	if pid := strings.TrimSpace(string(buf[:n])); pid != "" {
+		return pid
+	}
+	return "unknown"
+}
@@ -0,0 +1,268 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+	"xtunnel/logger"
+	"xtunnel/service"
+)
+
+const (
+	eventBuffer     = 256
+	shutdownTimeout = 2 * time.Second
+)
+
+// Server exposes the tunnels of a running instance as json over http on the control socket,
+// reload is called when the config store changed behind the instance's back
+type Server struct {
+	manager  *service.TunnelManager
+	reload   func(ctx context.Context) error
+	activate func()
+
+	http   *http.Server
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewServer(manager *service.TunnelManager, reload func(ctx context.Context) error) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		manager: manager,
+		reload:  reload,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// SetActivateHandler registers what a second launch of xtunnel triggers, like raising the window
+func (s *Server) SetActivateHandler(activate func()) {
+	s.activate = activate
+}
+
+// Start listens on the control socket, a socket left behind by a crashed instance is replaced
+func (s *Server) Start(ctx context.Context) error {
+	path, err := SocketPath(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logger.Error(ctx, "control socket mkdir error", g.Map{"path": path, "error": err.Error()})
+		return fmt.Errorf("control socket mkdir error")
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is used by another instance", path)
+	}
+	os.Remove(path)
+
+	listener, err := listenUnix(path)
+	if err != nil {
+		logger.Error(ctx, "control socket listen error", g.Map{"path": path, "error": err.Error()})
+		return fmt.Errorf("control socket listen error: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("control socket chmod error: %w", err)
+	}
+
+	s.http = &http.Server{
+		Handler:     s.routes(),
+		BaseContext: func(net.Listener) context.Context { return s.ctx },
+	}
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(ctx, "control server error", g.Map{"error": err.Error()})
+		}
+	}()
+
+	logger.Info(ctx, "control server started", g.Map{"path": path})
+	return nil
+}
+
+// Stop ends the event streams and closes the socket
+func (s *Server) Stop(ctx context.Context) {
+	s.cancel()
+	if s.http == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(ctx); err != nil {
+		logger.Error(ctx, "control server shutdown error", g.Map{"error": err.Error()})
+	}
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/tunnels", s.handleList)
+	mux.HandleFunc("GET /v1/tunnels/{ref}", s.handleStatus)
+	mux.HandleFunc("POST /v1/tunnels/{ref}/start", s.handleStart)
+	mux.HandleFunc("POST /v1/tunnels/{ref}/stop", s.handleStop)
+	mux.HandleFunc("POST /v1/reload", s.handleReload)
+	mux.HandleFunc("POST /v1/activate", s.handleActivate)
+	mux.HandleFunc("GET /v1/events", s.handleEvents)
+	return mux
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	configs, err := loadConfigs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	states := make([]TunnelState, 0, len(configs))
+	for _, config := range configs {
+		states = append(states, State(r.Context(), s.manager, config))
+	}
+	writeJSON(w, http.StatusOK, states)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	config, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, State(r.Context(), s.manager, config))
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	config, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	// a config added after the instance came up is picked up on demand
+	if _, err := s.manager.StatusTunnel(ctx, config.Identifier); err != nil && s.reload != nil {
+		if err := s.reload(ctx); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// the tunnel outlives the request, it must not be stopped by the request context
+	if err := s.manager.StartTunnel(s.ctx, config.Identifier); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	logger.Info(ctx, "tunnel started by control api", g.Map{"identifier": config.Identifier})
+	writeJSON(w, http.StatusOK, State(ctx, s.manager, config))
+}
+
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	config, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.manager.StopTunnel(ctx, config.Identifier); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	logger.Info(ctx, "tunnel stopped by control api", g.Map{"identifier": config.Identifier})
+	writeJSON(w, http.StatusOK, State(ctx, s.manager, config))
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.reload == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := s.reload(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleActivate raises the window, an instance without one refuses so the launcher can tell why nothing opens
+func (s *Server) handleActivate(w http.ResponseWriter, r *http.Request) {
+	if s.activate == nil {
+		writeError(w, http.StatusConflict, ErrHeadless)
+		return
+	}
+
+	s.activate()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams the events of every tunnel as json lines until the client goes away
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	events, unsubscribe := s.manager.Subscribe(eventBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := encoder.Encode(newEventMessage(event)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*service.ConfigFile, bool) {
+	configs, err := loadConfigs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	config, err := service.FindConfig(configs, r.PathValue("ref"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	return config, true
+}
+
+// loadConfigs leaves corrupt config files out, the store logs them
+func loadConfigs(ctx context.Context) ([]*service.ConfigFile, error) {
+	configs, err := service.DefaultConfigStore().Load(ctx)
+
+	var loadErr *service.ConfigLoadError
+	if errors.As(err, &loadErr) {
+		return configs, nil
+	}
+	return configs, err
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorMessage{Error: err.Error()})
+}
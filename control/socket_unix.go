@@ -0,0 +1,17 @@
+//go:build !windows
+
+package control
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix creates the socket without access for group and others, a chmod after net.Listen would leave
+// it open to them in between. The umask is process wide, files created meanwhile only get stricter modes.
+func listenUnix(path string) (net.Listener, error) {
+	umask := syscall.Umask(0077)
+	defer syscall.Umask(umask)
+
+	return net.Listen("unix", path)
+}
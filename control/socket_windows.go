@@ -0,0 +1,10 @@
+//go:build windows
+
+package control
+
+import "net"
+
+// listenUnix creates the socket, its access follows the acl of the profile dir it is in
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"xtunnel/logger"
+	"xtunnel/service"
+)
+
+// Daemon runs the configured tunnels without a window, the ones flagged for autostart are started right away.
+// Unknown host keys are rejected since nobody can confirm them, connect once from the window to trust a host.
+type Daemon struct {
+	manager    *service.TunnelManager
+	stopEvents func()
+}
+
+func New() *Daemon {
+	return &Daemon{
+		manager: service.NewTunnelManager(),
+	}
+}
+
+func (d *Daemon) Manager() *service.TunnelManager {
+	return d.manager
+}
+
+func (d *Daemon) Start(ctx context.Context) error {
+	d.stopEvents = d.manager.OnEvent(func(event service.Event) {
+		d.logEvent(ctx, event)
+	})
+
+	// without the passphrase tunnels with stored credentials fail to start, the others run as usual
+	if err := service.Secrets.UnlockFromEnv(ctx); err != nil {
+		return err
+	}
+	if service.Secrets.Locked() && service.Secrets.Exists(ctx) {
+		logger.Info(ctx, "secret store is locked", g.Map{"env": service.MasterPassphraseEnv})
+	}
+
+	files, err := d.reload(ctx)
+	if err != nil {
+		return err
+	}
+
+	started := 0
+	for _, file := range files {
+		if !file.AutoStart {
+			continue
+		}
+
+		if err := d.manager.StartTunnel(ctx, file.Identifier); err != nil {
+			logger.Error(ctx, "daemon start tunnel error", g.Map{"identifier": file.Identifier, "name": file.ConfigName, "err": err.Error()})
+			continue
+		}
+		started++
+	}
+
+	logger.Info(ctx, "daemon started", g.Map{"tunnels": len(files), "autostart": started})
+	if started == 0 {
+		logger.Info(ctx, "no tunnel is flagged for autostart", g.Map{})
+	}
+
+	return nil
+}
+
+// Reload follows the config store, running tunnels keep running unless their config changed
+func (d *Daemon) Reload(ctx context.Context) error {
+	_, err := d.reload(ctx)
+	return err
+}
+
+func (d *Daemon) reload(ctx context.Context) ([]*service.ConfigFile, error) {
+	files, err := service.DefaultConfigStore().Load(ctx)
+	// the readable configs still run, the corrupt ones are already logged by the store
+	var loadErr *service.ConfigLoadError
+	if errors.As(err, &loadErr) {
+		logger.Error(ctx, "daemon skipped corrupt config files", g.Map{"files": len(loadErr.Files), "err": err.Error()})
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("daemon load config error: %w", err)
+	}
+
+	return d.manager.SyncConfigs(ctx, files), nil
+}
+
+// Stop stops every tunnel and waits until their listeners and connections are closed
+func (d *Daemon) Stop(ctx context.Context) {
+	d.manager.StopAll(ctx)
+	if d.stopEvents != nil {
+		d.stopEvents()
+	}
+
+	logger.Info(ctx, "daemon stopped", g.Map{})
+}
+
+// logEvent records status changes, the tunnels log connections and errors themselves
+func (d *Daemon) logEvent(ctx context.Context, event service.Event) {
+	if event.Type != service.EventStatus {
+		return
+	}
+
+	data := g.Map{"identifier": event.Identifier, "status": event.Status.String()}
+	if event.Failure != nil {
+		data["reason"] = event.Failure.Reason
+		data["err"] = event.Failure.Err.Error()
+		logger.Error(ctx, "tunnel status changed", data)
+		return
+	}
+
+	logger.Info(ctx, "tunnel status changed", data)
+}
@@ -2,21 +2,88 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
 	"os"
 	"os/signal"
 	"syscall"
+	"xtunnel/cli"
+	"xtunnel/control"
+	"xtunnel/daemon"
 	"xtunnel/logger"
+	"xtunnel/service"
 	"xtunnel/views"
 )
 
 func main() {
+	headless := flag.Bool("headless", false, "run the tunnels flagged for autostart without a window, same as the daemon command")
+	flag.Parse()
+
 	logger.Init()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	win := views.NewWindow(ctx, cancel)
-	go win.Run()
+	if flag.NArg() > 0 && flag.Arg(0) != "daemon" {
+		// the output of the commands is meant for scripts, logs only go to the log files
+		logger.Logger.SetStdoutPrint(false)
+
+		ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		err := cli.New().Run(ctx, flag.Args())
+		stop()
+		if err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(os.Stderr, "xtunnel: %s\n", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	daemonMode := *headless || flag.Arg(0) == "daemon"
+
+	// a second launch hands over to the running instance instead of fighting it for the ports
+	lock, err := control.AcquireLock(ctx)
+	if errors.Is(err, control.ErrLocked) {
+		if err := handOff(ctx, daemonMode, err); err != nil {
+			fmt.Fprintf(os.Stderr, "xtunnel: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		logger.Error(ctx, "instance lock error", g.Map{"err": err.Error()})
+	}
+	defer lock.Release()
+
+	var server *control.Server
+	var shutdown func(ctx context.Context)
+	if daemonMode {
+		d := daemon.New()
+		if err := d.Start(ctx); err != nil {
+			logger.Error(ctx, "daemon start error", g.Map{"err": err.Error()})
+			os.Exit(1)
+		}
+		server = control.NewServer(d.Manager(), d.Reload)
+		shutdown = d.Stop
+	} else {
+		// the window asks for the passphrase itself, with the env var autostart tunnels come up right away
+		if err := service.Secrets.UnlockFromEnv(ctx); err != nil {
+			logger.Error(ctx, "secret store unlock error", g.Map{"err": err.Error()})
+		}
+		win := views.NewWindow(ctx, cancel)
+		go win.Run()
+		server = control.NewServer(win.Manager(), win.Reload)
+		server.SetActivateHandler(win.Activate)
+		shutdown = win.Destroy
+	}
+
+	// the tunnels work without the control api, only the cli and scripts lose their handle on them
+	if err := server.Start(ctx); err != nil {
+		logger.Error(ctx, "control server start error", g.Map{"err": err.Error()})
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
@@ -25,6 +92,30 @@ func main() {
 	select {
 	case <-quit:
 	case <-ctx.Done():
-		win.Destroy(ctx)
 	}
+
+	server.Stop(ctx)
+	shutdown(ctx)
+}
+
+// handOff raises the window of the running instance, a second daemon has nothing to hand over and fails
+// and so does a window while a headless instance runs
+func handOff(ctx context.Context, daemonMode bool, locked error) error {
+	if daemonMode {
+		return locked
+	}
+
+	client, err := control.WaitDial(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, but its control socket does not answer: %w", locked, err)
+	}
+	// a window of its own would fight the headless instance for the ports, the user has to stop that one first
+	if err := client.Activate(ctx); errors.Is(err, control.ErrHeadless) {
+		return fmt.Errorf("%w: %w, end it to open the window", locked, err)
+	} else if err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "handed over to the running instance", g.Map{})
+	return nil
 }
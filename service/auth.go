@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"xtunnel/logger"
+)
+
+const (
+	AuthTypePassword    = "password"
+	AuthTypeKey         = "key"
+	AuthTypeKeyPassword = "key_password"
+	AuthTypeAgent       = "agent"
+)
+
+type AuthConfig struct {
+	Username       string
+	AuthType       string
+	Password       string
+	PrivateKeyPath string
+	PrivateKey     string
+	Passphrase     string
+	// AgentFingerprint limits agent auth to a single key, SHA256 or legacy MD5 format
+	AgentFingerprint string
+}
+
+// resolve returns a copy with the secret store references replaced by the credentials they stand for
+func (a AuthConfig) resolve(ctx context.Context) (AuthConfig, error) {
+	for _, field := range []*string{&a.Password, &a.PrivateKey, &a.Passphrase} {
+		value, err := ResolveSecret(ctx, *field)
+		if err != nil {
+			return a, fmt.Errorf("%w: %w", ErrSecretUnavailable, err)
+		}
+		*field = value
+	}
+	return a, nil
+}
+
+// authMethods returns the auth methods and a release func which must be called once the handshake is done
+func (a *AuthConfig) authMethods(ctx context.Context) ([]ssh.AuthMethod, func(), error) {
+	noop := func() {}
+	switch a.AuthType {
+	case "", AuthTypePassword:
+		return []ssh.AuthMethod{ssh.Password(a.Password)}, noop, nil
+	case AuthTypeKey:
+		signer, err := a.signer()
+		if err != nil {
+			return nil, noop, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, noop, nil
+	case AuthTypeKeyPassword:
+		// the key is offered first, the server falls through to the password when it rejects the key
+		signer, err := a.signer()
+		if err != nil {
+			logger.Error(ctx, "private key load error, fallback to password", g.Map{"username": a.Username, "err": err.Error()})
+			return []ssh.AuthMethod{ssh.Password(a.Password)}, noop, nil
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer), ssh.Password(a.Password)}, noop, nil
+	case AuthTypeAgent:
+		return a.agentAuthMethods(ctx)
+	}
+
+	return nil, noop, fmt.Errorf("unsupported auth type: %s", a.AuthType)
+}
+
+func (a *AuthConfig) agentAuthMethods(ctx context.Context) ([]ssh.AuthMethod, func(), error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, func() {}, fmt.Errorf("ssh agent not available: SSH_AUTH_SOCK is empty")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		logger.Error(ctx, "ssh agent dial error", g.Map{"socket": socket, "err": err.Error()})
+		return nil, func() {}, fmt.Errorf("ssh agent dial error: %w", err)
+	}
+	release := func() { conn.Close() }
+
+	client := agent.NewClient(conn)
+	signers, err := client.Signers()
+	if err != nil {
+		release()
+		return nil, func() {}, fmt.Errorf("ssh agent list keys error: %w", err)
+	}
+
+	if a.AgentFingerprint != "" {
+		signers = filterSigners(signers, a.AgentFingerprint)
+	}
+
+	if len(signers) == 0 {
+		release()
+		return nil, func() {}, fmt.Errorf("ssh agent has no matching key")
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, release, nil
+}
+
+func filterSigners(signers []ssh.Signer, fingerprint string) []ssh.Signer {
+	fingerprint = strings.TrimSpace(fingerprint)
+	matched := make([]ssh.Signer, 0, 1)
+	for _, signer := range signers {
+		key := signer.PublicKey()
+		if ssh.FingerprintSHA256(key) == fingerprint ||
+			ssh.FingerprintLegacyMD5(key) == strings.TrimPrefix(fingerprint, "MD5:") {
+			matched = append(matched, signer)
+		}
+	}
+
+	return matched
+}
+
+func (a *AuthConfig) signer() (ssh.Signer, error) {
+	pemBytes := []byte(a.PrivateKey)
+	if strings.TrimSpace(a.PrivateKey) == "" {
+		if a.PrivateKeyPath == "" {
+			return nil, fmt.Errorf("private key is empty")
+		}
+
+		content, err := os.ReadFile(expandHome(a.PrivateKeyPath))
+		if err != nil {
+			return nil, fmt.Errorf("read private key error: %w", err)
+		}
+		pemBytes = content
+	}
+
+	if a.Passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(a.Passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("parse private key error: %w", err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key is protected by passphrase")
+		}
+		return nil, fmt.Errorf("parse private key error: %w", err)
+	}
+
+	return signer, nil
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+}
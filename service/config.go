@@ -3,24 +3,278 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gogf/gf/v2/frame/g"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 	"xtunnel/logger"
 )
 
+// ConfigFile is one tunnel in the config store. The credentials hold references once saved, to the secret store
+// or to a source like env:NAME, cmd:COMMAND and file:PATH, see ResolveSecret. Plaintext from older files is moved
+// into the secret store when they are loaded with the store unlocked.
 type ConfigFile struct {
-	Identifier string `json:"identifier"`
-	FileName   string `json:"file_name"`
-	ConfigName string `json:"config_name"`
+	SchemaVersion    int    `json:"schema_version"`
+	Identifier       string `json:"identifier"`
+	FileName         string `json:"file_name"`
+	ConfigName       string `json:"config_name"`
+	RemoteIP         string `json:"remote_ip"`
+	RemotePort       string `json:"remote_port"`
+	ServerIP         string `json:"server_ip"`
+	ServerPort       string `json:"server_port"`
+	Mode             string `json:"mode"`
+	LocalIP          string `json:"local_ip"`
+	LocalPort        string `json:"local_port"`
+	SocksUsername    string `json:"socks_username"`
+	SocksPassword    string `json:"socks_password"`
+	UserName         string `json:"user_name"`
+	AuthType         string `json:"auth_type"`
+	Password         string `json:"password"`
+	PrivateKeyPath   string `json:"private_key_path"`
+	PrivateKey       string `json:"private_key"`
+	Passphrase       string `json:"passphrase"`
+	AgentFingerprint string `json:"agent_fingerprint"`
+	// KeepAliveInterval is in seconds, both keepalive settings use the defaults when empty
+	KeepAliveInterval  string `json:"keepalive_interval"`
+	KeepAliveMaxMissed string `json:"keepalive_max_missed"`
+	// ReconnectAttempts uses DefaultReconnectAttempts when empty, "0" disables reconnecting
+	ReconnectAttempts string `json:"reconnect_attempts"`
+	// AutoStart tunnels are started when the daemon or the window comes up
+	AutoStart bool `json:"autostart"`
+
+	JumpHosts []*JumpHostConfig `json:"jump_hosts"`
+	// Forwards are extra rules sharing the ssh connection of the main rule above,
+	// dynamic ones use the socks credentials of the main rule
+	Forwards []*ForwardConfig `json:"forwards"`
+}
+
+type ForwardConfig struct {
+	Mode       string `json:"mode"`
+	LocalIP    string `json:"local_ip"`
+	LocalPort  string `json:"local_port"`
 	RemoteIP   string `json:"remote_ip"`
 	RemotePort string `json:"remote_port"`
-	ServerIP   string `json:"server_ip"`
-	ServerPort string `json:"server_port"`
-	UserName   string `json:"user_name"`
-	Password   string `json:"password"`
+}
+
+type JumpHostConfig struct {
+	IP               string `json:"ip"`
+	Port             string `json:"port"`
+	UserName         string `json:"user_name"`
+	AuthType         string `json:"auth_type"`
+	Password         string `json:"password"`
+	PrivateKeyPath   string `json:"private_key_path"`
+	PrivateKey       string `json:"private_key"`
+	Passphrase       string `json:"passphrase"`
+	AgentFingerprint string `json:"agent_fingerprint"`
+}
+
+func (c *ConfigFile) TunnelConfig() *TunnelConfig {
+	config := &TunnelConfig{
+		AuthConfig: AuthConfig{
+			Username:         c.UserName,
+			AuthType:         c.AuthType,
+			Password:         c.Password,
+			PrivateKeyPath:   c.PrivateKeyPath,
+			PrivateKey:       c.PrivateKey,
+			Passphrase:       c.Passphrase,
+			AgentFingerprint: c.AgentFingerprint,
+		},
+		ServerAddr: fmt.Sprintf("%s:%s", c.ServerIP, c.ServerPort),
+	}
+
+	if interval, err := strconv.Atoi(c.KeepAliveInterval); err == nil {
+		config.KeepAliveInterval = time.Duration(interval) * time.Second
+	}
+	if maxMissed, err := strconv.Atoi(c.KeepAliveMaxMissed); err == nil {
+		config.KeepAliveMaxMissed = maxMissed
+	}
+	config.ReconnectAttempts = DefaultReconnectAttempts
+	if attempts, err := strconv.Atoi(c.ReconnectAttempts); err == nil {
+		config.ReconnectAttempts = attempts
+	}
+
+	for _, forward := range c.AllForwards() {
+		config.Forwards = append(config.Forwards, ForwardRule{
+			Mode:          forward.Mode,
+			LocalAddr:     forward.LocalAddr(),
+			RemoteAddr:    forward.RemoteAddr(),
+			SocksUsername: c.SocksUsername,
+			SocksPassword: c.SocksPassword,
+		})
+	}
+
+	for _, hop := range c.JumpHosts {
+		config.JumpHosts = append(config.JumpHosts, JumpHost{
+			AuthConfig: AuthConfig{
+				Username:         hop.UserName,
+				AuthType:         hop.AuthType,
+				Password:         hop.Password,
+				PrivateKeyPath:   hop.PrivateKeyPath,
+				PrivateKey:       hop.PrivateKey,
+				Passphrase:       hop.Passphrase,
+				AgentFingerprint: hop.AgentFingerprint,
+			},
+			Addr: fmt.Sprintf("%s:%s", hop.IP, hop.Port),
+		})
+	}
+
+	return config
+}
+
+// secrets points at every credential of the config, the jump hosts included
+func (c *ConfigFile) secrets() []*string {
+	fields := []*string{&c.Password, &c.PrivateKey, &c.Passphrase, &c.SocksPassword}
+	for _, hop := range c.JumpHosts {
+		fields = append(fields, &hop.Password, &hop.PrivateKey, &hop.Passphrase)
+	}
+	return fields
+}
+
+func (c *ConfigFile) secretRefs() []string {
+	refs := make([]string, 0)
+	for _, field := range c.secrets() {
+		if IsSecretRef(*field) {
+			refs = append(refs, *field)
+		}
+	}
+	return refs
+}
+
+func (c *ConfigFile) HasSecretRefs() bool {
+	return len(c.secretRefs()) > 0
+}
+
+func (c *ConfigFile) HasPlaintextSecrets() bool {
+	for _, field := range c.secrets() {
+		if *field != "" && !IsCredentialRef(*field) {
+			return true
+		}
+	}
+	return false
+}
+
+// SealSecrets moves the plaintext credentials into the secret store and keeps references in their place,
+// references to other sources are kept as they are
+func (c *ConfigFile) SealSecrets(ctx context.Context) error {
+	for _, field := range c.secrets() {
+		if *field == "" || IsCredentialRef(*field) {
+			continue
+		}
+
+		ref, err := Secrets.Put(ctx, *field)
+		if err != nil {
+			return fmt.Errorf("config secret seal error: %w", err)
+		}
+		*field = ref
+	}
+	return nil
+}
+
+// CopySecrets replaces the secret refs of the config with refs to copies of the same values. A copy kept in
+// another store must have refs of its own, deleting it there would delete the secrets of the original.
+func (c *ConfigFile) CopySecrets(ctx context.Context) error {
+	for _, field := range c.secrets() {
+		if !IsSecretRef(*field) {
+			continue
+		}
+
+		value, err := Secrets.Get(ctx, *field)
+		if err != nil {
+			return fmt.Errorf("config secret copy error: %w", err)
+		}
+		ref, err := Secrets.Put(ctx, value)
+		if err != nil {
+			return fmt.Errorf("config secret copy error: %w", err)
+		}
+		*field = ref
+	}
+	return nil
+}
+
+// credentialKeys are the json keys of the fields secrets() points at, for configs handled as decoded json
+var credentialKeys = []string{"password", "private_key", "passphrase", "socks_password"}
+
+// sealRaw is SealSecrets for a decoded config file, it keeps whatever else the file holds as it is
+func sealRaw(ctx context.Context, raw map[string]any) (bool, error) {
+	changed := false
+	seal := func(fields map[string]any) error {
+		for _, key := range credentialKeys {
+			value, _ := fields[key].(string)
+			if value == "" || IsCredentialRef(value) {
+				continue
+			}
+
+			ref, err := Secrets.Put(ctx, value)
+			if err != nil {
+				return fmt.Errorf("config secret seal error: %w", err)
+			}
+			fields[key] = ref
+			changed = true
+		}
+		return nil
+	}
+
+	if err := seal(raw); err != nil {
+		return changed, err
+	}
+	hops, _ := raw["jump_hosts"].([]any)
+	for _, hop := range hops {
+		if hop, ok := hop.(map[string]any); ok {
+			if err := seal(hop); err != nil {
+				return changed, err
+			}
+		}
+	}
+	return changed, nil
+}
+
+// AllForwards returns the main rule followed by the extra rules
+func (c *ConfigFile) AllForwards() []*ForwardConfig {
+	forwards := []*ForwardConfig{{
+		Mode:       c.Mode,
+		LocalIP:    c.LocalIP,
+		LocalPort:  c.LocalPort,
+		RemoteIP:   c.RemoteIP,
+		RemotePort: c.RemotePort,
+	}}
+
+	return append(forwards, c.Forwards...)
+}
+
+// LocalAddr falls back to 127.0.0.1 and the remote port for configs saved before local fields existed,
+// port 0 lets the system pick a free port.
+func (f *ForwardConfig) LocalAddr() string {
+	localIP := f.LocalIP
+	if localIP == "" {
+		localIP = "127.0.0.1"
+	}
+
+	localPort := f.LocalPort
+	if localPort == "" {
+		localPort = f.RemotePort
+	}
+
+	return net.JoinHostPort(localIP, localPort)
+}
+
+func (f *ForwardConfig) RemoteAddr() string {
+	switch f.Mode {
+	case ForwardModeDynamic:
+		return ""
+	case ForwardModeRemote:
+		// like ssh -R the server side listens on loopback unless a bind address is given
+		if f.RemoteIP == "" {
+			return net.JoinHostPort("127.0.0.1", f.RemotePort)
+		}
+	}
+
+	return net.JoinHostPort(f.RemoteIP, f.RemotePort)
 }
 
 func (c *ConfigFile) DeleteConfigFile(ctx context.Context) error {
@@ -34,21 +288,41 @@ func (c *ConfigFile) DeleteConfigFile(ctx context.Context) error {
 		return fmt.Errorf("config file ensure dir error: %w", err)
 	}
 
-	path := filepath.Join(configPath, fileName)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	path := c.path(configPath)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
 		logger.Error(ctx, "config file not exists", g.Map{"filename": fileName, "error": err.Error()})
 		return fmt.Errorf("config file not exists")
 	}
 
+	// the refs are taken from the file, the caller may only know its name, the backups go along with it
+	refs := c.secretRefs()
+	stored := &ConfigFile{}
+	if err == nil && json.Unmarshal(content, stored) == nil {
+		refs = stored.secretRefs()
+	}
+	refs = append(refs, storedRefs(backupPaths(path)...)...)
+
 	if err := os.Remove(path); err != nil {
 		logger.Error(ctx, "config file delete error", g.Map{"filename": fileName, "error": err.Error()})
 		return fmt.Errorf("config file delete error")
 	}
+	for _, backup := range backupPaths(path) {
+		os.Remove(backup)
+	}
 
+	if err := Secrets.Delete(ctx, refs...); err != nil {
+		logger.Error(ctx, "config secrets delete error", g.Map{"filename": fileName, "error": err.Error()})
+	}
 	return nil
 }
 
+// UpdateConfigFile replaces the file atomically, the previous version is kept next to it as a .bak
 func (c *ConfigFile) UpdateConfigFile(ctx context.Context) error {
+	return c.update(ctx, true)
+}
+
+func (c *ConfigFile) update(ctx context.Context, backup bool) error {
 	fileName := c.FileName
 	if fileName == "" {
 		return fmt.Errorf("invalid config file")
@@ -59,30 +333,52 @@ func (c *ConfigFile) UpdateConfigFile(ctx context.Context) error {
 		return fmt.Errorf("config file ensure dir error: %w", err)
 	}
 
-	path := filepath.Join(configPath, fileName)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	path := c.path(configPath)
+	previous, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
 		logger.Error(ctx, "config file not exists", g.Map{"filename": fileName, "error": err.Error()})
 		return fmt.Errorf("config file not exists")
 	}
-
-	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
 	if err != nil {
-		logger.Error(ctx, "config file open error", g.Map{"filename": fileName, "error": err.Error()})
-		return fmt.Errorf("config file open error")
+		logger.Error(ctx, "config file read error", g.Map{"filename": fileName, "error": err.Error()})
+		return fmt.Errorf("config file read error")
 	}
-	defer file.Close()
 
+	if err := c.SealSecrets(ctx); err != nil {
+		return err
+	}
+
+	c.SchemaVersion = ConfigSchemaVersion
 	newContent, err := json.Marshal(c)
 	if err != nil {
 		return err
 	}
 
-	_, err = file.Write(newContent)
-	if err != nil {
+	// the backup about to be replaced may hold the last reference to a secret
+	replaced := storedRefs(backupPath(path))
+	if backup {
+		if err := writeFileAtomic(backupPath(path), previous, 0600); err != nil {
+			logger.Error(ctx, "config file backup error", g.Map{"filename": fileName, "error": err.Error()})
+			return fmt.Errorf("config file backup error")
+		}
+	}
+
+	if err := writeFileAtomic(path, newContent, 0600); err != nil {
 		logger.Error(ctx, "config file write error", g.Map{"filename": fileName, "error": err.Error()})
 		return fmt.Errorf("config file write error")
 	}
 
+	// secrets the previous version or the replaced backup referenced are dropped once neither this version
+	// nor a backup still does, a restored backup would otherwise point at nothing
+	old := &ConfigFile{}
+	if json.Unmarshal(previous, old) == nil {
+		replaced = append(replaced, old.secretRefs()...)
+	}
+	used := append(c.secretRefs(), storedRefs(backupPaths(path)...)...)
+	if err := Secrets.Delete(ctx, unusedRefs(replaced, used)...); err != nil {
+		logger.Error(ctx, "config secrets delete error", g.Map{"filename": fileName, "error": err.Error()})
+	}
+
 	logger.Info(ctx, "config file updated", g.Map{"filename": fileName})
 	return nil
 }
@@ -93,8 +389,13 @@ func (c *ConfigFile) SaveConfigFile(ctx context.Context) error {
 		return err
 	}
 
+	if err := c.SealSecrets(ctx); err != nil {
+		return err
+	}
+
 	fileName := filepath.Join(configPath, fmt.Sprintf("%d.json", time.Now().UnixMicro()))
-	c.FileName = fileName
+	c.FileName = filepath.Base(fileName)
+	c.SchemaVersion = ConfigSchemaVersion
 	if c.ConfigName == "" {
 		c.ConfigName = fmt.Sprintf("%s:%s", c.RemoteIP, c.RemotePort)
 	}
@@ -105,7 +406,7 @@ func (c *ConfigFile) SaveConfigFile(ctx context.Context) error {
 		return err
 	}
 
-	if err := os.WriteFile(fileName, fileContent, 0644); err != nil {
+	if err := writeFileAtomic(fileName, fileContent, 0600); err != nil {
 		logger.Error(ctx, "config file write error", g.Map{"filename": fileName, "error": err.Error()})
 		return fmt.Errorf("config file write error")
 	}
@@ -127,25 +428,169 @@ func (c *ConfigFile) LoadConfigFile(ctx context.Context) ([]*ConfigFile, error)
 		return nil, fmt.Errorf("load config files error")
 	}
 
+	loadErr := &ConfigLoadError{}
 	for _, file := range files {
+		// backups and the temp files of an interrupted write are no configs
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
 		path := filepath.Join(configPath, file.Name())
 		config, err := os.ReadFile(path)
 		if err != nil {
 			logger.Error(ctx, "read config file error", g.Map{"file_path": path, "error": err.Error()})
+			loadErr.add(path, err)
+			continue
+		}
+
+		migrated, version, err := migrateConfig(config)
+		if err != nil {
+			logger.Error(ctx, "migrate config file error", g.Map{"file_name": file.Name(), "error": err.Error()})
+			loadErr.add(path, err)
 			continue
 		}
 
 		conf := &ConfigFile{}
-		if err = json.Unmarshal(config, conf); err != nil {
+		if err = json.Unmarshal(migrated, conf); err != nil {
 			logger.Error(ctx, "unmarshal config file error", g.Map{"file_name": file.Name(), "error": err.Error()})
+			loadErr.add(path, err)
 			continue
 		}
+
+		// the migrated config is used even when the file cannot be rewritten, the next load tries again.
+		// The backup would keep plaintext credentials, a file with some waits for the secret store to be unlocked.
+		if version != ConfigSchemaVersion && (!conf.HasPlaintextSecrets() || !Secrets.Locked()) {
+			if sealed, err := migrateFile(ctx, path, config); err != nil {
+				logger.Error(ctx, "config file migrate error", g.Map{"file_name": file.Name(), "error": err.Error()})
+			} else {
+				conf = sealed
+			}
+		}
+		if !Secrets.Locked() {
+			sealSchemaBackups(ctx, path)
+		}
+
+		// files from before the secret store keep working in plaintext until the store is unlocked,
+		// the plaintext version is not kept as a backup
+		if conf.HasPlaintextSecrets() && !Secrets.Locked() {
+			if err := conf.update(ctx, false); err != nil {
+				logger.Error(ctx, "config secrets migrate error", g.Map{"file_name": file.Name(), "error": err.Error()})
+			} else {
+				logger.Info(ctx, "config secrets migrated", g.Map{"file_name": file.Name()})
+			}
+		}
 		configs = append(configs, conf)
 	}
 
+	if len(loadErr.Files) > 0 {
+		return configs, loadErr
+	}
 	return configs, nil
 }
 
+// ConfigLoadError reports the config files a ConfigStore skipped, the configs returned along with it are the readable ones
+type ConfigLoadError struct {
+	Files []*CorruptConfig
+}
+
+type CorruptConfig struct {
+	Path string
+	// Backup is the previous version of the file, empty when there is none
+	Backup string
+	Err    error
+}
+
+func (e *ConfigLoadError) add(path string, err error) {
+	corrupt := &CorruptConfig{Path: path, Err: err}
+	// an older version of a file from a newer xtunnel is no fix for it
+	if errors.Is(err, ErrConfigSchemaTooNew) {
+		e.Files = append(e.Files, corrupt)
+		return
+	}
+	if _, err := os.Stat(backupPath(path)); err == nil {
+		corrupt.Backup = backupPath(path)
+	}
+	e.Files = append(e.Files, corrupt)
+}
+
+func (e *ConfigLoadError) Error() string {
+	messages := make([]string, 0, len(e.Files))
+	for _, file := range e.Files {
+		messages = append(messages, file.String())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (c *CorruptConfig) String() string {
+	message := fmt.Sprintf("config file %s cannot be loaded: %s", c.Path, c.Err)
+	if c.Backup != "" {
+		message += fmt.Sprintf(", its previous version is in %s", c.Backup)
+	}
+	return message
+}
+
+// FindConfig looks a config up by identifier or by name, names are not unique so an ambiguous name is an error
+func FindConfig(configs []*ConfigFile, ref string) (*ConfigFile, error) {
+	var matches []*ConfigFile
+	for _, config := range configs {
+		if config.Identifier == ref {
+			return config, nil
+		}
+		if config.ConfigName == ref {
+			matches = append(matches, config)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("tunnel %q not found", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("tunnel name %q is ambiguous, use the identifier instead", ref)
+	}
+}
+
+// storedRefs returns the secret refs of the config files at paths, files which cannot be read are skipped
+func storedRefs(paths ...string) []string {
+	refs := make([]string, 0)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		stored := &ConfigFile{}
+		if json.Unmarshal(content, stored) == nil {
+			refs = append(refs, stored.secretRefs()...)
+		}
+	}
+	return refs
+}
+
+// backupPaths are the .bak of path and its schema backups
+func backupPaths(path string) []string {
+	paths := []string{backupPath(path)}
+	for version := 0; version < ConfigSchemaVersion; version++ {
+		paths = append(paths, schemaBackupPath(path, version))
+	}
+	return paths
+}
+
+func unusedRefs(refs []string, used []string) []string {
+	unused := make([]string, 0)
+	for _, ref := range refs {
+		if !slices.Contains(used, ref) {
+			unused = append(unused, ref)
+		}
+	}
+	return unused
+}
+
+// path tolerates FileName holding the full path, as SaveConfigFile stored it before schema version 1
+func (c *ConfigFile) path(configPath string) string {
+	return filepath.Join(configPath, filepath.Base(c.FileName))
+}
+
 func (c *ConfigFile) EnsureDir(ctx context.Context) (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -156,7 +601,7 @@ func (c *ConfigFile) EnsureDir(ctx context.Context) (string, error) {
 	configPath := filepath.Join(homeDir, "XTunnel", ".config")
 	if _, err := os.Stat(configPath); err != nil {
 		if os.IsNotExist(err) {
-			if err := os.MkdirAll(configPath, 0644); err != nil {
+			if err := os.MkdirAll(configPath, 0700); err != nil {
 				logger.Error(ctx, "config file mkdir error", g.Map{"config_path": configPath, "error": err.Error()})
 				return "", fmt.Errorf("config file mkdir error")
 			}
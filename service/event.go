@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xtunnel/logger"
+)
+
+type EventType string
+
+const (
+	// EventStatus carries the new Status, and Failure once the tunnel failed
+	EventStatus EventType = "status"
+	// EventConnOpened and EventConnClosed are sent per forwarded connection, the closed event carries its byte counts
+	EventConnOpened EventType = "conn_opened"
+	EventConnClosed EventType = "conn_closed"
+	// EventError reports errors the tunnel keeps running after, like a failed dial of one connection
+	EventError EventType = "error"
+	// EventTraffic carries the byte totals of the tunnel, it is sent at most once per trafficInterval while they change
+	EventTraffic EventType = "traffic"
+)
+
+const (
+	trafficInterval = 1 * time.Second
+	eventQueueSize  = 1024
+)
+
+type Event struct {
+	Type       EventType
+	Identifier string
+	Time       time.Time
+
+	Status  TunnelStatus
+	Failure *TunnelFailure
+	Err     error
+
+	// Client is the address of the accepted connection, Target the address it is forwarded to
+	Client string
+	Target string
+
+	// BytesSent flows from the accepted connection to the target, BytesReceived the other way
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// eventBus fans events out to subscribers from a single goroutine, so emitting never waits on a consumer
+// and consumers may call back into the manager. Events are dropped and counted while the queue is full,
+// as a handler which blocks would otherwise stall every tunnel.
+type eventBus struct {
+	queue    chan Event
+	dropped  atomic.Uint64
+	reported uint64
+	mu       sync.Mutex
+	nextID   int
+	channels map[int]chan Event
+	handlers map[int]func(Event)
+}
+
+func newEventBus() *eventBus {
+	bus := &eventBus{
+		queue:    make(chan Event, eventQueueSize),
+		channels: make(map[int]chan Event),
+		handlers: make(map[int]func(Event)),
+	}
+	go bus.run()
+	return bus
+}
+
+func (b *eventBus) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	select {
+	case b.queue <- event:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+func (b *eventBus) run() {
+	for event := range b.queue {
+		if dropped := b.dropped.Load(); dropped != b.reported {
+			logger.Error(context.Background(), "events dropped", g.Map{"dropped": dropped - b.reported})
+			b.reported = dropped
+		}
+
+		b.mu.Lock()
+		handlers := make([]func(Event), 0, len(b.handlers))
+		for _, handler := range b.handlers {
+			handlers = append(handlers, handler)
+		}
+		for _, ch := range b.channels {
+			// a slow subscriber misses events rather than stalling every tunnel
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+		b.mu.Unlock()
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+func (b *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, buffer)
+	b.channels[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.channels, id)
+			close(ch)
+		})
+	}
+}
+
+func (b *eventBus) onEvent(handler func(Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
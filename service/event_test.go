@@ -0,0 +1,41 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmitDoesNotBlockOnSlowHandler(t *testing.T) {
+	bus := newEventBus()
+	block := make(chan struct{})
+	defer close(block)
+
+	received := make(chan struct{}, 1)
+	bus.onEvent(func(event Event) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		<-block
+	})
+
+	bus.emit(Event{Type: EventTraffic})
+	<-received
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*eventQueueSize; i++ {
+			bus.emit(Event{Type: EventTraffic})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("emit blocks while the handler is stuck")
+	}
+
+	if dropped := bus.dropped.Load(); dropped != eventQueueSize {
+		t.Errorf("dropped = %d, want %d", dropped, eventQueueSize)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"syscall"
+)
+
+type FailureReason string
+
+const (
+	ReasonUnknown           FailureReason = "unknown"
+	ReasonAuthFailed        FailureReason = "auth_failed"
+	ReasonHostUnreachable   FailureReason = "host_unreachable"
+	ReasonHostKeyMismatch   FailureReason = "host_key_mismatch"
+	ReasonHostKeyRejected   FailureReason = "host_key_rejected"
+	ReasonPortInUse         FailureReason = "port_in_use"
+	ReasonConnectionLost    FailureReason = "connection_lost"
+	ReasonSecretUnavailable FailureReason = "secret_unavailable"
+)
+
+var (
+	ErrAuthFailed = errors.New("ssh authentication failed")
+	ErrPortInUse  = errors.New("port already in use")
+	// ErrSecretUnavailable wraps errors resolving a credential, like a locked secret store
+	ErrSecretUnavailable = errors.New("credential unavailable")
+)
+
+// TunnelFailure is the error a tunnel carries in StatusFailed, Reason tells why it went down
+type TunnelFailure struct {
+	Reason FailureReason
+	Err    error
+}
+
+func (f *TunnelFailure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Reason, f.Err.Error())
+}
+
+func (f *TunnelFailure) Unwrap() error {
+	return f.Err
+}
+
+// newFailure classifies err, the more specific reasons win when err chains several of them
+func newFailure(err error) *TunnelFailure {
+	var failure *TunnelFailure
+	if errors.As(err, &failure) {
+		return failure
+	}
+
+	return &TunnelFailure{Reason: failureReason(err), Err: err}
+}
+
+func failureReason(err error) FailureReason {
+	var opErr *net.OpError
+	var chanErr *ssh.OpenChannelError
+
+	switch {
+	case errors.Is(err, ErrHostKeyMismatch):
+		return ReasonHostKeyMismatch
+	case errors.Is(err, ErrHostKeyRejected):
+		return ReasonHostKeyRejected
+	case errors.Is(err, ErrSecretUnavailable):
+		return ReasonSecretUnavailable
+	case errors.Is(err, ErrAuthFailed):
+		return ReasonAuthFailed
+	case errors.Is(err, ErrPortInUse):
+		return ReasonPortInUse
+	case errors.Is(err, ErrConnectionLost):
+		return ReasonConnectionLost
+	case errors.As(err, &opErr) && opErr.Op == "dial", errors.As(err, &chanErr):
+		return ReasonHostUnreachable
+	default:
+		return ReasonUnknown
+	}
+}
+
+// authError marks handshake errors caused by rejected credentials
+func authError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "unable to authenticate") {
+		return fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	}
+	return err
+}
+
+// listenError marks errors of listeners whose address is taken, locally or on the ssh server
+func listenError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	if errors.Is(err, syscall.EADDRINUSE) ||
+		strings.Contains(message, "address already in use") ||
+		strings.Contains(message, "Only one usage of each socket address") ||
+		strings.Contains(message, "tcpip-forward request denied") {
+		return fmt.Errorf("%w: %w", ErrPortInUse, err)
+	}
+	return err
+}
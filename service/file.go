@@ -0,0 +1,50 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const backupSuffix = ".bak"
+
+// writeFileAtomic replaces path through a synced temp file in the same dir,
+// a crash leaves either the old or the new content behind, never a mix of both
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(content)
+	if err == nil {
+		err = temp.Chmod(perm)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir makes a rename durable, it is best effort as not every platform can sync a dir
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func backupPath(path string) string {
+	return path + backupSuffix
+}
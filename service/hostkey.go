@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"xtunnel/logger"
+)
+
+var (
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+	ErrHostKeyRejected = errors.New("host key rejected")
+)
+
+type HostKeyMismatchError struct {
+	Host        string
+	Fingerprint string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s, got %s, possible man-in-the-middle attack", e.Host, e.Fingerprint)
+}
+
+func (e *HostKeyMismatchError) Unwrap() error {
+	return ErrHostKeyMismatch
+}
+
+// HostKeyConfirmFunc asks the user whether an unknown host key is trusted
+type HostKeyConfirmFunc func(ctx context.Context, host string, keyType string, fingerprint string) bool
+
+// HostKeyStore checks host keys against ~/.ssh/known_hosts and the XTunnel owned known_hosts file,
+// unknown hosts are trusted on first use after confirmation and recorded in the XTunnel file.
+type HostKeyStore struct {
+	mu      sync.Mutex
+	confirm HostKeyConfirmFunc
+}
+
+func NewHostKeyStore() *HostKeyStore {
+	return &HostKeyStore{}
+}
+
+func (s *HostKeyStore) SetConfirmFunc(confirm HostKeyConfirmFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.confirm = confirm
+}
+
+func (s *HostKeyStore) Callback(ctx context.Context) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		s.mu.Lock()
+		unknown, err := s.verify(ctx, hostname, remote, key)
+		confirm := s.confirm
+		s.mu.Unlock()
+		if !unknown {
+			return err
+		}
+
+		// the dialog waits for the user, other connections must not wait for it
+		fingerprint := ssh.FingerprintSHA256(key)
+		if confirm == nil || !confirm(ctx, hostname, key.Type(), fingerprint) {
+			logger.Error(ctx, "host key rejected", g.Map{"host": hostname, "fingerprint": fingerprint})
+			return fmt.Errorf("%w: %s %s", ErrHostKeyRejected, hostname, fingerprint)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// another connection may have trusted the host while the dialog was open
+		if unknown, err := s.verify(ctx, hostname, remote, key); !unknown {
+			return err
+		}
+		return s.trust(ctx, hostname, key)
+	}
+}
+
+// verify checks key against the known hosts, unknown tells that the host has no key yet and may be trusted.
+// The caller holds s.mu.
+func (s *HostKeyStore) verify(ctx context.Context, hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	check, err := s.checker(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	err = check(hostname, remote, key)
+	if err == nil {
+		return false, nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return false, err
+	}
+
+	if len(keyErr.Want) > 0 {
+		fingerprint := ssh.FingerprintSHA256(key)
+		logger.Error(ctx, "host key mismatch", g.Map{"host": hostname, "fingerprint": fingerprint})
+		return false, &HostKeyMismatchError{Host: hostname, Fingerprint: fingerprint}
+	}
+	return true, nil
+}
+
+// Algorithms returns the key algorithms already known for the host, so the server
+// does not offer a key of another type that would be reported as a mismatch.
+func (s *HostKeyStore) Algorithms(ctx context.Context, addr string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	check, err := s.checker(ctx)
+	if err != nil {
+		return nil
+	}
+
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+
+	// an unknown host has no key to stick to, an empty list would leave the handshake no algorithm at all
+	var keyErr *knownhosts.KeyError
+	if err := check(addr, &net.TCPAddr{}, probe); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+
+	algorithms := make([]string, 0, len(keyErr.Want))
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+
+	return algorithms
+}
+
+func (s *HostKeyStore) checker(ctx context.Context) (ssh.HostKeyCallback, error) {
+	path, err := s.ensureFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		userFile := filepath.Join(homeDir, ".ssh", "known_hosts")
+		if _, err := os.Stat(userFile); err == nil {
+			files = append(files, userFile)
+		}
+	}
+
+	check, err := knownhosts.New(files...)
+	if err != nil {
+		logger.Error(ctx, "load known_hosts error", g.Map{"files": files, "err": err.Error()})
+		return nil, fmt.Errorf("load known_hosts error: %w", err)
+	}
+
+	return check, nil
+}
+
+func (s *HostKeyStore) trust(ctx context.Context, hostname string, key ssh.PublicKey) error {
+	path, err := s.ensureFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Error(ctx, "known_hosts open error", g.Map{"path": path, "err": err.Error()})
+		return fmt.Errorf("known_hosts open error")
+	}
+	defer file.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		logger.Error(ctx, "known_hosts write error", g.Map{"path": path, "err": err.Error()})
+		return fmt.Errorf("known_hosts write error")
+	}
+
+	logger.Info(ctx, "host key trusted", g.Map{"host": hostname, "fingerprint": ssh.FingerprintSHA256(key)})
+	return nil
+}
+
+func (s *HostKeyStore) ensureFile(ctx context.Context) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logger.Error(ctx, "cannot find home dir", g.Map{"error": err.Error()})
+		return "", fmt.Errorf("cannot find home dir")
+	}
+
+	path := filepath.Join(homeDir, "XTunnel", "known_hosts")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logger.Error(ctx, "known_hosts mkdir error", g.Map{"path": path, "error": err.Error()})
+		return "", fmt.Errorf("known_hosts mkdir error")
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.Error(ctx, "known_hosts create error", g.Map{"path": path, "error": err.Error()})
+		return "", fmt.Errorf("known_hosts create error")
+	}
+
+	return path, file.Close()
+}
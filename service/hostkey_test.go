@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyConfirmDoesNotHoldStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewHostKeyStore()
+
+	asked := make(chan struct{})
+	answer := make(chan bool)
+	store.SetConfirmFunc(func(ctx context.Context, host string, keyType string, fingerprint string) bool {
+		close(asked)
+		return <-answer
+	})
+
+	host := "confirm.example.com:22"
+	key := testHostKey(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Callback(ctx)(host, &net.TCPAddr{}, key)
+	}()
+	<-asked
+
+	// another connection looks the store up while the dialog is open
+	looked := make(chan struct{})
+	go func() {
+		store.Algorithms(ctx, "other.example.com:22")
+		close(looked)
+	}()
+	select {
+	case <-looked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the store is locked while the confirm dialog waits")
+	}
+
+	answer <- true
+	if err := <-done; err != nil {
+		t.Fatalf("trusted key: %s", err)
+	}
+	if err := store.Callback(ctx)(host, &net.TCPAddr{}, key); err != nil {
+		t.Fatalf("known key: %s", err)
+	}
+}
+
+func TestHostKeyTrustedOnce(t *testing.T) {
+	ctx := context.Background()
+	store := NewHostKeyStore()
+
+	asked := make(chan struct{}, 2)
+	answer := make(chan bool)
+	store.SetConfirmFunc(func(ctx context.Context, host string, keyType string, fingerprint string) bool {
+		asked <- struct{}{}
+		return <-answer
+	})
+
+	host := "twice.example.com:22"
+	key := testHostKey(t)
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- store.Callback(ctx)(host, &net.TCPAddr{}, key)
+		}()
+	}
+	<-asked
+	<-asked
+	answer <- true
+	answer <- true
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("trusted key: %s", err)
+		}
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	content, err := os.ReadFile(filepath.Join(homeDir, "XTunnel", "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "twice.example.com"); lines != 1 {
+		t.Errorf("host recorded %d times, want once", lines)
+	}
+}
+
+func TestAlgorithmsUnknownHost(t *testing.T) {
+	if algorithms := NewHostKeyStore().Algorithms(context.Background(), "unknown.example.com:22"); algorithms != nil {
+		t.Errorf("algorithms = %v, an unknown host must leave the defaults", algorithms)
+	}
+}
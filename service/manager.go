@@ -4,20 +4,59 @@ import (
 	"context"
 	"fmt"
 	"github.com/gogf/gf/v2/frame/g"
+	"reflect"
 	"sync"
 	"xtunnel/logger"
 )
 
 type TunnelManager struct {
-	tunnels map[string]*Tunnel
-	mutex   sync.Mutex
+	tunnels  map[string]*Tunnel
+	hostKeys *HostKeyStore
+	pool     *ClientPool
+	events   *eventBus
+	mutex    sync.Mutex
 }
 
 func NewTunnelManager() *TunnelManager {
 	return &TunnelManager{
-		tunnels: make(map[string]*Tunnel),
+		tunnels:  make(map[string]*Tunnel),
+		hostKeys: NewHostKeyStore(),
+		pool:     NewClientPool(),
+		events:   newEventBus(),
 	}
 }
+
+// SetHostKeyConfirm registers the prompt used to trust unknown host keys, unknown keys are rejected without it
+func (tm *TunnelManager) SetHostKeyConfirm(confirm HostKeyConfirmFunc) {
+	tm.hostKeys.SetConfirmFunc(confirm)
+}
+
+// Subscribe delivers the events of every tunnel to the returned channel, events are dropped while its buffer is full.
+// The channel is closed by the returned cancel func.
+func (tm *TunnelManager) Subscribe(buffer int) (<-chan Event, func()) {
+	return tm.events.subscribe(buffer)
+}
+
+// OnEvent calls handler for the events of every tunnel until the returned func is called,
+// handlers run one after another on a single goroutine and should return quickly, events are dropped while they lag
+func (tm *TunnelManager) OnEvent(handler func(Event)) func() {
+	return tm.events.onEvent(handler)
+}
+
+// DroppedEvents counts the events which were not delivered as the handlers fell behind
+func (tm *TunnelManager) DroppedEvents() uint64 {
+	return tm.events.dropped.Load()
+}
+
+func (tm *TunnelManager) newTunnel(identifier string, config *TunnelConfig) *Tunnel {
+	tunnel := NewTunnel(config)
+	tunnel.identifier = identifier
+	tunnel.hostKeys = tm.hostKeys
+	tunnel.pool = tm.pool
+	tunnel.events = tm.events
+	return tunnel
+}
+
 func (tm *TunnelManager) AddTunnel(ctx context.Context, identifier string, config *TunnelConfig) (*Tunnel, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
@@ -27,13 +66,105 @@ func (tm *TunnelManager) AddTunnel(ctx context.Context, identifier string, confi
 		return nil, fmt.Errorf("[%s] tunnel already exists", identifier)
 	}
 
-	tunnel := NewTunnel(config)
-	tunnel.identifier = identifier
+	tunnel := tm.newTunnel(identifier, config)
 	tm.tunnels[identifier] = tunnel
 	logger.Info(ctx, "tunnel added", g.Map{"identifier": identifier})
 	return tunnel, nil
 }
 
+// UpdateTunnel replaces the config of a tunnel, an active tunnel is restarted with the new config
+func (tm *TunnelManager) UpdateTunnel(ctx context.Context, identifier string, config *TunnelConfig) error {
+	tm.mutex.Lock()
+	tunnel, ok := tm.tunnels[identifier]
+	if !ok {
+		tm.mutex.Unlock()
+		return fmt.Errorf("[%s] tunnel not exists", identifier)
+	}
+
+	if reflect.DeepEqual(tunnel.config, config) {
+		tm.mutex.Unlock()
+		return nil
+	}
+
+	status := tunnel.Status()
+	active := status != StatusStopped && status != StatusFailed
+	replacement := tm.newTunnel(identifier, config)
+	tm.tunnels[identifier] = replacement
+	tm.mutex.Unlock()
+
+	// stopping waits for the open connections, the other tunnels stay reachable meanwhile
+	tunnel.Stop(ctx)
+	logger.Info(ctx, "tunnel updated", g.Map{"identifier": identifier, "restart": active})
+
+	if active {
+		tm.start(ctx, replacement)
+	}
+
+	return nil
+}
+
+// RemoveTunnel stops the tunnel and forgets it
+func (tm *TunnelManager) RemoveTunnel(ctx context.Context, identifier string) error {
+	tm.mutex.Lock()
+	tunnel, ok := tm.tunnels[identifier]
+	if !ok {
+		tm.mutex.Unlock()
+		return fmt.Errorf("[%s] tunnel not exists", identifier)
+	}
+	delete(tm.tunnels, identifier)
+	tm.mutex.Unlock()
+
+	tunnel.Stop(ctx)
+	logger.Info(ctx, "tunnel removed", g.Map{"identifier": identifier})
+	return nil
+}
+
+// SyncConfigs adds, updates and removes tunnels so the manager follows configs, running tunnels keep running
+// unless their config changed. It returns the configs whose tunnel is in the manager.
+func (tm *TunnelManager) SyncConfigs(ctx context.Context, configs []*ConfigFile) []*ConfigFile {
+	stale := make(map[string]bool)
+	for _, identifier := range tm.Identifiers() {
+		stale[identifier] = true
+	}
+
+	synced := make([]*ConfigFile, 0, len(configs))
+	for _, config := range configs {
+		var err error
+		if stale[config.Identifier] {
+			delete(stale, config.Identifier)
+			err = tm.UpdateTunnel(ctx, config.Identifier, config.TunnelConfig())
+		} else {
+			_, err = tm.AddTunnel(ctx, config.Identifier, config.TunnelConfig())
+		}
+
+		if err != nil {
+			logger.Error(ctx, "sync tunnel error", g.Map{"identifier": config.Identifier, "name": config.ConfigName, "err": err.Error()})
+			continue
+		}
+		synced = append(synced, config)
+	}
+
+	for identifier := range stale {
+		if err := tm.RemoveTunnel(ctx, identifier); err != nil {
+			logger.Error(ctx, "remove tunnel error", g.Map{"identifier": identifier, "err": err.Error()})
+		}
+	}
+
+	return synced
+}
+
+// Identifiers lists the tunnels known to the manager
+func (tm *TunnelManager) Identifiers() []string {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	identifiers := make([]string, 0, len(tm.tunnels))
+	for identifier := range tm.tunnels {
+		identifiers = append(identifiers, identifier)
+	}
+	return identifiers
+}
+
 func (tm *TunnelManager) StartTunnel(ctx context.Context, identifier string) error {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
@@ -42,42 +173,67 @@ func (tm *TunnelManager) StartTunnel(ctx context.Context, identifier string) err
 		return fmt.Errorf("[%s] tunnel not exists", identifier)
 	}
 
-	if tunnel.status != StatusStopped {
+	if tunnel.Status() == StatusFailed {
+		config := *tunnel.config
+		tunnel = tm.newTunnel(identifier, &config)
+		tm.tunnels[identifier] = tunnel
+	}
+
+	if tunnel.Status() != StatusStopped {
 		return fmt.Errorf("[%s] tunnel already running", identifier)
 	}
 
+	tm.start(ctx, tunnel)
+	return nil
+}
+
+// start moves the tunnel to StatusStarting right away and connects in the background, a Stop coming
+// before the connect cancels it. The outcome is reported through the status events.
+func (tm *TunnelManager) start(ctx context.Context, tunnel *Tunnel) {
+	if err := tunnel.begin(ctx); err != nil {
+		return
+	}
 	go func() {
-		if err := tunnel.Start(ctx); err != nil {
+		if err := tunnel.run(ctx); err != nil {
 			logger.Error(ctx, "tunnel start error", g.Map{"identifier": tunnel.identifier, "err": err.Error()})
 		}
 	}()
-
-	return nil
 }
 
+// StopTunnel swaps in a stopped tunnel with the same config, the old one is stopped outside the lock
+// as it waits for its open connections
 func (tm *TunnelManager) StopTunnel(ctx context.Context, identifier string) error {
 	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
 	tunnel, ok := tm.tunnels[identifier]
 	if !ok {
+		tm.mutex.Unlock()
 		return fmt.Errorf("[%s] tunnel not exists", identifier)
 	}
-	tm.tunnels[identifier] = NewTunnel(&TunnelConfig{
-		Username:   tunnel.config.Username,
-		Password:   tunnel.config.Password,
-		LocalAddr:  tunnel.config.LocalAddr,
-		ServerAddr: tunnel.config.ServerAddr,
-		RemoteAddr: tunnel.config.RemoteAddr,
-	})
+	config := *tunnel.config
+	tm.tunnels[identifier] = tm.newTunnel(identifier, &config)
+	tm.mutex.Unlock()
 
 	tunnel.Stop(ctx)
 	return nil
 }
 
 func (tm *TunnelManager) StopAll(ctx context.Context) {
+	tm.mutex.Lock()
+	tunnels := make([]*Tunnel, 0, len(tm.tunnels))
 	for _, tunnel := range tm.tunnels {
-		tunnel.Stop(ctx)
+		tunnels = append(tunnels, tunnel)
 	}
+	tm.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, tunnel := range tunnels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tunnel.Stop(ctx)
+		}()
+	}
+	wg.Wait()
 }
 
 func (tm *TunnelManager) StatusTunnel(ctx context.Context, identifier string) (TunnelStatus, error) {
@@ -89,5 +245,46 @@ func (tm *TunnelManager) StatusTunnel(ctx context.Context, identifier string) (T
 		return 0, fmt.Errorf("[%s] tunnel not exists", identifier)
 	}
 
-	return tunnel.status, nil
+	return tunnel.Status(), nil
+}
+
+// Failure tells why a tunnel is in StatusFailed, nil when it is not failed
+func (tm *TunnelManager) Failure(ctx context.Context, identifier string) (*TunnelFailure, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tunnel, ok := tm.tunnels[identifier]
+	if !ok {
+		return nil, fmt.Errorf("[%s] tunnel not exists", identifier)
+	}
+
+	if tunnel.Status() != StatusFailed {
+		return nil, nil
+	}
+
+	return tunnel.Failure(), nil
+}
+
+func (tm *TunnelManager) Stats(ctx context.Context, identifier string) (TunnelStats, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tunnel, ok := tm.tunnels[identifier]
+	if !ok {
+		return TunnelStats{}, fmt.Errorf("[%s] tunnel not exists", identifier)
+	}
+
+	return tunnel.Stats(), nil
+}
+
+func (tm *TunnelManager) ListenAddrs(ctx context.Context, identifier string) ([]string, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tunnel, ok := tm.tunnels[identifier]
+	if !ok {
+		return nil, fmt.Errorf("[%s] tunnel not exists", identifier)
+	}
+
+	return tunnel.ListenAddrs(), nil
 }
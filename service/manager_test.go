@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func testTunnelConfig(server *testServer, target string) *TunnelConfig {
+	return &TunnelConfig{
+		AuthConfig: AuthConfig{Username: "u", AuthType: AuthTypePassword, Password: server.password},
+		ServerAddr: server.addr,
+		Forwards:   []ForwardRule{{Mode: ForwardModeLocal, LocalAddr: "127.0.0.1:0", RemoteAddr: target}},
+	}
+}
+
+func newTestManager() *TunnelManager {
+	tm := NewTunnelManager()
+	tm.SetHostKeyConfirm(func(ctx context.Context, host string, keyType string, fingerprint string) bool {
+		return true
+	})
+	return tm
+}
+
+func waitStatus(t *testing.T, tm *TunnelManager, identifier string, want TunnelStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := tm.StatusTunnel(context.Background(), identifier)
+		if err == nil && status == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	status, _ := tm.StatusTunnel(context.Background(), identifier)
+	failure, _ := tm.Failure(context.Background(), identifier)
+	t.Fatalf("tunnel %s is %s, want %s, failure: %v", identifier, status, want, failure)
+}
+
+// the connection is shared with another running tunnel so it stays open, the stop must not wait for the
+// forwarded connection to end on its own and must not hold up the manager meanwhile
+func TestStopTunnelWithOpenConnection(t *testing.T) {
+	ctx := context.Background()
+	server := startTestServer(t, "p")
+	echo := startEchoServer(t)
+	tm := newTestManager()
+	defer tm.StopAll(ctx)
+
+	for _, identifier := range []string{"a", "b"} {
+		if _, err := tm.AddTunnel(ctx, identifier, testTunnelConfig(server, echo)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tm.StartTunnel(ctx, identifier); err != nil {
+			t.Fatal(err)
+		}
+		waitStatus(t, tm, identifier, StatusRunning)
+	}
+	if server.handshakes.Load() != 1 {
+		t.Fatalf("%d ssh connections, the tunnels should share one", server.handshakes.Load())
+	}
+
+	addrs, err := tm.ListenAddrs(ctx, "a")
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("listen addrs %v: %v", addrs, err)
+	}
+	conn, err := net.Dial("tcp", addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "ping\n" {
+		t.Fatalf("echo through the tunnel: %q, %v", line, err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		tm.StopTunnel(ctx, "a")
+		close(stopped)
+	}()
+
+	status := make(chan TunnelStatus)
+	go func() {
+		s, _ := tm.StatusTunnel(ctx, "b")
+		status <- s
+	}()
+	select {
+	case s := <-status:
+		if s != StatusRunning {
+			t.Errorf("the other tunnel is %s", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("StatusTunnel blocks while a tunnel stops")
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopTunnel waits for the open forwarded connection")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("the forwarded connection is still open after the stop")
+	}
+	waitStatus(t, tm, "b", StatusRunning)
+}
+
+func TestStartWithWrongPasswordIsNotRetried(t *testing.T) {
+	ctx := context.Background()
+	server := startTestServer(t, "p")
+	tm := newTestManager()
+	defer tm.StopAll(ctx)
+
+	config := testTunnelConfig(server, startEchoServer(t))
+	config.Password = "wrong"
+	if _, err := tm.AddTunnel(ctx, "a", config); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.StartTunnel(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, tm, "a", StatusFailed)
+
+	failure, _ := tm.Failure(ctx, "a")
+	if failure == nil || failure.Reason != ReasonAuthFailed {
+		t.Errorf("failure = %v, want %s", failure, ReasonAuthFailed)
+	}
+	if attempts := server.authAttempts.Load(); attempts != 1 {
+		t.Errorf("%d password attempts, a refused password must not be retried", attempts)
+	}
+}
+
+// the stop comes before the background start ran, the start must be canceled instead of leaving
+// a tunnel nothing owns holding the local port
+func TestStopRightAfterStart(t *testing.T) {
+	ctx := context.Background()
+	server := startTestServer(t, "p")
+	tm := newTestManager()
+	defer tm.StopAll(ctx)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localAddr := listener.Addr().String()
+	listener.Close()
+
+	config := testTunnelConfig(server, startEchoServer(t))
+	config.Forwards[0].LocalAddr = localAddr
+	if _, err := tm.AddTunnel(ctx, "a", config); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := tm.StartTunnel(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tm.StopTunnel(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if status, _ := tm.StatusTunnel(ctx, "a"); status != StatusStopped {
+		t.Fatalf("tunnel is %s after the stop", status)
+	}
+	listener, err = net.Listen("tcp", localAddr)
+	if err != nil {
+		t.Fatalf("local port still taken after the stop: %s", err)
+	}
+	listener.Close()
+}
+
+func TestStartFailureReasons(t *testing.T) {
+	ctx := context.Background()
+	server := startTestServer(t, "p")
+	echo := startEchoServer(t)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	tests := []struct {
+		name   string
+		modify func(config *TunnelConfig)
+		want   FailureReason
+	}{
+		{"host unreachable", func(config *TunnelConfig) { config.ServerAddr = closedAddr }, ReasonHostUnreachable},
+		{"port in use", func(config *TunnelConfig) { config.Forwards[0].LocalAddr = taken.Addr().String() }, ReasonPortInUse},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tm := newTestManager()
+			defer tm.StopAll(ctx)
+
+			config := testTunnelConfig(server, echo)
+			test.modify(config)
+			if _, err := tm.AddTunnel(ctx, "a", config); err != nil {
+				t.Fatal(err)
+			}
+			if err := tm.StartTunnel(ctx, "a"); err != nil {
+				t.Fatal(err)
+			}
+			waitStatus(t, tm, "a", StatusFailed)
+
+			failure, _ := tm.Failure(ctx, "a")
+			if failure == nil || failure.Reason != test.want {
+				t.Errorf("failure = %v, want %s", failure, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"os"
+	"path/filepath"
+	"xtunnel/logger"
+)
+
+// ConfigSchemaVersion is the layout SaveConfigFile and UpdateConfigFile write, files without schema_version are version 0
+const ConfigSchemaVersion = 1
+
+var ErrConfigSchemaTooNew = errors.New("config file was written by a newer version of xtunnel")
+
+// configMigration upgrades the decoded json of a config file by one version
+type configMigration func(raw map[string]any) error
+
+// configMigrations[i] upgrades version i to i+1, a layout change appends one and bumps ConfigSchemaVersion
+var configMigrations = []configMigration{
+	migrateConfigV0,
+}
+
+// migrateConfigV0 spells out the defaults older files left empty, and stores the file name without the dir
+// as SaveConfigFile used to store the full path
+func migrateConfigV0(raw map[string]any) error {
+	if fileName, ok := raw["file_name"].(string); ok && fileName != "" {
+		raw["file_name"] = filepath.Base(fileName)
+	}
+
+	setDefault(raw, "mode", ForwardModeLocal)
+	setDefault(raw, "auth_type", AuthTypePassword)
+	migrateLocalAddrV0(raw)
+
+	if hops, ok := raw["jump_hosts"].([]any); ok {
+		for _, hop := range hops {
+			if hop, ok := hop.(map[string]any); ok {
+				setDefault(hop, "auth_type", AuthTypePassword)
+			}
+		}
+	}
+	if forwards, ok := raw["forwards"].([]any); ok {
+		for _, forward := range forwards {
+			if forward, ok := forward.(map[string]any); ok {
+				setDefault(forward, "mode", ForwardModeLocal)
+				migrateLocalAddrV0(forward)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateLocalAddrV0 fills the local address the way LocalAddr falls back for a missing one
+func migrateLocalAddrV0(rule map[string]any) {
+	if rule["mode"] == ForwardModeDynamic {
+		return
+	}
+	setDefault(rule, "local_ip", "127.0.0.1")
+	if remotePort, ok := rule["remote_port"].(string); ok {
+		setDefault(rule, "local_port", remotePort)
+	}
+}
+
+func setDefault(raw map[string]any, key string, value string) {
+	if current, _ := raw[key].(string); current == "" {
+		raw[key] = value
+	}
+}
+
+// migrateConfig upgrades content to ConfigSchemaVersion, the version it was in is returned along with it.
+// Files from a newer version are refused, rewriting them with this layout would drop what it does not know.
+func migrateConfig(content []byte) ([]byte, int, error) {
+	raw := make(map[string]any)
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, 0, err
+	}
+
+	version, err := schemaVersion(raw, 0)
+	if err != nil {
+		return nil, version, err
+	}
+	if version == ConfigSchemaVersion {
+		return content, version, nil
+	}
+
+	if err := upgradeConfig(raw, version); err != nil {
+		return nil, version, err
+	}
+	raw["schema_version"] = ConfigSchemaVersion
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, version, err
+	}
+	return migrated, version, nil
+}
+
+// schemaVersion reads schema_version from raw, missing is the version given. Newer versions are an error.
+func schemaVersion(raw map[string]any, missing int) (int, error) {
+	value, ok := raw["schema_version"]
+	if !ok {
+		return missing, nil
+	}
+
+	number, ok := value.(float64)
+	if !ok || number < 0 || number != float64(int(number)) {
+		return 0, fmt.Errorf("invalid schema_version: %v", value)
+	}
+	version := int(number)
+	if version > ConfigSchemaVersion {
+		return version, fmt.Errorf("%w: schema version %d, this one reads up to %d", ErrConfigSchemaTooNew, version, ConfigSchemaVersion)
+	}
+	return version, nil
+}
+
+// upgradeConfig runs the migrations from version up to ConfigSchemaVersion on one decoded config
+func upgradeConfig(raw map[string]any, version int) error {
+	for v := version; v < ConfigSchemaVersion; v++ {
+		if err := configMigrations[v](raw); err != nil {
+			return fmt.Errorf("config migration from schema version %d error: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// migrateFile rewrites a file in an older layout. Its credentials are sealed in the original first,
+// so neither the backup nor the migrated file keeps them in plaintext.
+func migrateFile(ctx context.Context, path string, original []byte) (*ConfigFile, error) {
+	raw := make(map[string]any)
+	if err := json.Unmarshal(original, &raw); err != nil {
+		return nil, err
+	}
+	if _, err := sealRaw(ctx, raw); err != nil {
+		return nil, err
+	}
+	sealed, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated, version, err := migrateConfig(sealed)
+	if err != nil {
+		return nil, err
+	}
+	conf := &ConfigFile{}
+	if err := json.Unmarshal(migrated, conf); err != nil {
+		return nil, err
+	}
+
+	if err := rewriteMigrated(ctx, path, sealed, migrated, version); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// sealSchemaBackups seals the credentials left in plaintext in the schema backups of path,
+// as the ones written before they were sealed along with the file
+func sealSchemaBackups(ctx context.Context, path string) {
+	for version := 0; version < ConfigSchemaVersion; version++ {
+		backup := schemaBackupPath(path, version)
+		content, err := os.ReadFile(backup)
+		if err != nil {
+			continue
+		}
+
+		raw := make(map[string]any)
+		if json.Unmarshal(content, &raw) != nil {
+			continue
+		}
+		changed, err := sealRaw(ctx, raw)
+		if err != nil || !changed {
+			continue
+		}
+		if content, err = json.Marshal(raw); err == nil {
+			err = writeFileAtomic(backup, content, 0600)
+		}
+		if err != nil {
+			logger.Error(ctx, "config backup seal error", g.Map{"file_path": backup, "error": err.Error()})
+		}
+	}
+}
+
+// rewriteMigrated replaces a file loaded in an older layout with its migrated content,
+// the original is kept as a backup named after its version first
+func rewriteMigrated(ctx context.Context, path string, original []byte, migrated []byte, version int) error {
+	if err := writeFileAtomic(schemaBackupPath(path, version), original, 0600); err != nil {
+		logger.Error(ctx, "config file backup error", g.Map{"file_path": path, "error": err.Error()})
+		return fmt.Errorf("config file backup error")
+	}
+
+	if err := writeFileAtomic(path, migrated, 0600); err != nil {
+		logger.Error(ctx, "config file write error", g.Map{"file_path": path, "error": err.Error()})
+		return fmt.Errorf("config file write error")
+	}
+
+	logger.Info(ctx, "config file migrated", g.Map{"file_path": path, "from": version, "to": ConfigSchemaVersion})
+	return nil
+}
+
+// schemaBackupPath is where the file as it was before the migration from version is kept,
+// unlike the .bak of UpdateConfigFile it is not replaced by later updates
+func schemaBackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d%s", path, version, backupSuffix)
+}
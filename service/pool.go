@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gogf/gf/v2/frame/g"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"sync"
+	"xtunnel/logger"
+)
+
+// ClientPool shares ssh connections between tunnels targeting the same server with the same credentials,
+// a connection is closed when the last tunnel using it releases it.
+type ClientPool struct {
+	mu      sync.Mutex
+	entries map[string]*pooledClient
+	// inUse finds the entry of a target client until its last release, also once it left entries
+	inUse map[*ssh.Client]*pooledClient
+}
+
+type pooledClient struct {
+	key     string
+	clients []*ssh.Client
+	refs    int
+	ready   chan struct{}
+	err     error
+	// dead is closed once the connection is discarded, every tunnel using it reconnects then
+	dead     chan struct{}
+	deadOnce sync.Once
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		entries: make(map[string]*pooledClient),
+		inUse:   make(map[*ssh.Client]*pooledClient),
+	}
+}
+
+// Acquire returns the shared client for key, dial is only called when there is no live connection,
+// the returned release func must be called once the client is no longer used.
+func (p *ClientPool) Acquire(ctx context.Context, key string, dial func() ([]*ssh.Client, error)) (*ssh.Client, func(), error) {
+	p.mu.Lock()
+	entry, ok := p.entries[key]
+	if ok {
+		entry.refs++
+		p.mu.Unlock()
+
+		<-entry.ready
+		if entry.err != nil {
+			return nil, nil, entry.err
+		}
+
+		logger.Info(ctx, "ssh connection reused", g.Map{"key": shortKey(key)})
+		return entry.target(), p.releaseFunc(entry), nil
+	}
+
+	entry = &pooledClient{key: key, refs: 1, ready: make(chan struct{}), dead: make(chan struct{})}
+	p.entries[key] = entry
+	p.mu.Unlock()
+
+	clients, err := dial()
+
+	p.mu.Lock()
+	if err != nil {
+		entry.err = err
+		if p.entries[key] == entry {
+			delete(p.entries, key)
+		}
+	} else {
+		entry.clients = clients
+		p.inUse[entry.target()] = entry
+	}
+	p.mu.Unlock()
+	close(entry.ready)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	go p.watch(entry)
+	return entry.target(), p.releaseFunc(entry), nil
+}
+
+func (p *ClientPool) releaseFunc(entry *pooledClient) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			entry.refs--
+			last := entry.refs == 0
+			if last && p.entries[entry.key] == entry {
+				delete(p.entries, entry.key)
+			}
+			if last {
+				delete(p.inUse, entry.target())
+			}
+			p.mu.Unlock()
+
+			if last {
+				closeClients(entry.clients)
+			}
+		})
+	}
+}
+
+// Discard marks the connection of client dead, the next Acquire dials a fresh one and the tunnels still
+// holding it see Dead and reconnect. It stays open for them until the last one releases it.
+func (p *ClientPool) Discard(client *ssh.Client) {
+	if client == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.inUse[client]; ok {
+		p.markDead(entry)
+	}
+}
+
+// Dead is closed once the connection of client is discarded or lost, a client the pool does not hold counts as dead
+func (p *ClientPool) Dead(client *ssh.Client) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.inUse[client]; ok {
+		return entry.dead
+	}
+
+	dead := make(chan struct{})
+	close(dead)
+	return dead
+}
+
+// watch drops a dead connection from the pool so the next Acquire dials a fresh one
+func (p *ClientPool) watch(entry *pooledClient) {
+	entry.target().Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.markDead(entry)
+}
+
+// markDead must be called with p.mu held
+func (p *ClientPool) markDead(entry *pooledClient) {
+	if p.entries[entry.key] == entry {
+		delete(p.entries, entry.key)
+	}
+	entry.deadOnce.Do(func() {
+		close(entry.dead)
+	})
+}
+
+func (c *pooledClient) target() *ssh.Client {
+	return c.clients[len(c.clients)-1]
+}
+
+// poolKey identifies the server, user and credentials of the whole hop chain, the last hop being the server,
+// without keeping secrets in memory as is. The hops carry the resolved credentials: every sealed value has
+// a ref of its own, so configs sharing a password would never share a connection by their refs.
+func poolKey(hops []JumpHost) string {
+	parts := make([]string, 0, len(hops))
+	for _, hop := range hops {
+		parts = append(parts, hop.Addr+"|"+hop.AuthConfig.poolKey())
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *AuthConfig) poolKey() string {
+	return strings.Join([]string{
+		a.Username,
+		a.AuthType,
+		a.Password,
+		a.PrivateKeyPath,
+		a.PrivateKey,
+		a.Passphrase,
+		a.AgentFingerprint,
+	}, "\x00")
+}
+
+func shortKey(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"golang.org/x/crypto/ssh"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func alive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+func TestDiscardNil(t *testing.T) {
+	NewClientPool().Discard(nil)
+}
+
+func TestDiscardUnknownClientLeavesItOpen(t *testing.T) {
+	server := startTestServer(t, "p")
+	client := server.dial(t)
+	defer client.Close()
+
+	NewClientPool().Discard(client)
+	if !alive(client) {
+		t.Fatal("discard closed a client the pool does not hold")
+	}
+}
+
+func TestDiscardMarksSharedClientDead(t *testing.T) {
+	ctx := context.Background()
+	server := startTestServer(t, "p")
+	pool := NewClientPool()
+
+	var dials atomic.Int64
+	dial := func() ([]*ssh.Client, error) {
+		dials.Add(1)
+		return []*ssh.Client{server.dial(t)}, nil
+	}
+
+	first, releaseFirst, err := pool.Acquire(ctx, "k", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := pool.Acquire(ctx, "k", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || dials.Load() != 1 {
+		t.Fatalf("the connection is not shared, %d dials", dials.Load())
+	}
+
+	dead := pool.Dead(first)
+	pool.Discard(first)
+
+	select {
+	case <-dead:
+	default:
+		t.Fatal("the users of a discarded connection are not told")
+	}
+	if !alive(first) {
+		t.Fatal("discard closed the connection under the tunnels sharing it")
+	}
+
+	fresh, releaseFresh, err := pool.Acquire(ctx, "k", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseFresh()
+	if fresh == first || dials.Load() != 2 {
+		t.Fatal("acquire after discard returned the discarded connection")
+	}
+
+	releaseFirst()
+	if !alive(first) {
+		t.Fatal("the connection was closed while a tunnel still holds it")
+	}
+	releaseSecond()
+
+	closed := make(chan struct{})
+	go func() {
+		first.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the discarded connection is not closed after the last release")
+	}
+}
+
+// every sealed value gets a ref of its own, two configs with the same password still share the connection
+func TestSealedCredentialsShareConnection(t *testing.T) {
+	ctx := context.Background()
+	unlockSecrets(t)
+	server := startTestServer(t, "p")
+	echo := startEchoServer(t)
+	tm := newTestManager()
+	defer tm.StopAll(ctx)
+
+	for _, identifier := range []string{"a", "b"} {
+		config := testTunnelConfig(server, echo)
+		ref, err := Secrets.Put(ctx, server.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Password = ref
+		if _, err := tm.AddTunnel(ctx, identifier, config); err != nil {
+			t.Fatal(err)
+		}
+		if err := tm.StartTunnel(ctx, identifier); err != nil {
+			t.Fatal(err)
+		}
+		waitStatus(t, tm, identifier, StatusRunning)
+	}
+
+	if handshakes := server.handshakes.Load(); handshakes != 1 {
+		t.Errorf("%d ssh connections, the tunnels should share one", handshakes)
+	}
+}
+
+func TestAcquireClosesAfterLastRelease(t *testing.T) {
+	ctx := context.Background()
+	server := startTestServer(t, "p")
+	pool := NewClientPool()
+
+	var dials atomic.Int64
+	dial := func() ([]*ssh.Client, error) {
+		dials.Add(1)
+		return []*ssh.Client{server.dial(t)}, nil
+	}
+
+	first, releaseFirst, err := pool.Acquire(ctx, "k", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, releaseOther, err := pool.Acquire(ctx, "other", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseOther()
+	if other == first || dials.Load() != 2 {
+		t.Fatal("different credentials share a connection")
+	}
+
+	second, releaseSecond, err := pool.Acquire(ctx, "k", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Fatal("the same credentials do not share the connection")
+	}
+
+	releaseFirst()
+	if !alive(first) {
+		t.Fatal("the connection was closed while a tunnel still holds it")
+	}
+	releaseSecond()
+
+	closed := make(chan struct{})
+	go func() {
+		first.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection is not closed after the last release")
+	}
+
+	fresh, releaseFresh, err := pool.Acquire(ctx, "k", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseFresh()
+	if fresh == first || dials.Load() != 3 {
+		t.Fatal("acquire after the last release returned the closed connection")
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const secretCommandTimeout = 30 * time.Second
+
+// SecretResolver turns the part of a credential reference after "scheme:" into the credential
+type SecretResolver func(ctx context.Context, ref string) (string, error)
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]SecretResolver{
+		"secret": func(ctx context.Context, ref string) (string, error) { return Secrets.Get(ctx, ref) },
+		"env":    resolveEnv,
+		"cmd":    resolveCommand,
+		"file":   resolveFile,
+	}
+)
+
+// RegisterSecretResolver adds a credential source, a config field "scheme:ref" is resolved by it when the tunnel connects
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[scheme] = resolver
+}
+
+func lookupResolver(value string) (SecretResolver, string, bool) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, "", false
+	}
+
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	resolver, ok := resolvers[scheme]
+	return resolver, ref, ok
+}
+
+// IsCredentialRef tells whether value names a source of the credential instead of holding it,
+// like secret:ID, env:NAME, cmd:COMMAND or file:PATH
+func IsCredentialRef(value string) bool {
+	_, _, ok := lookupResolver(value)
+	return ok
+}
+
+// ResolveSecret returns the credential a config field stands for, values that are no reference are returned unchanged
+func ResolveSecret(ctx context.Context, value string) (string, error) {
+	resolver, ref, ok := lookupResolver(value)
+	if !ok {
+		return value, nil
+	}
+	return resolver(ctx, ref)
+}
+
+func resolveEnv(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// resolveCommand runs command in the shell, like cmd:pass show bastion, its stdout is the credential
+func resolveCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			err = fmt.Errorf("%w: %s", err, message)
+		}
+		return "", fmt.Errorf("credential command %q error: %w", command, err)
+	}
+
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
+
+// resolveFile reads the credential from a file, a trailing newline is dropped and ~ stands for the home dir
+func resolveFile(ctx context.Context, path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot find home dir")
+		}
+		path = filepath.Join(homeDir, rest)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("credential file error: %w", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"golang.org/x/crypto/argon2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"xtunnel/logger"
+)
+
+// MasterPassphraseEnv unlocks the secret store without a prompt, for the daemon and scripts
+const MasterPassphraseEnv = "XTUNNEL_MASTER_PASSPHRASE"
+
+const (
+	secretRefPrefix   = "secret:"
+	secretFileVersion = 1
+	secretCheck       = "xtunnel"
+)
+
+var (
+	ErrSecretStoreLocked = errors.New("secret store is locked")
+	ErrWrongPassphrase   = errors.New("wrong master passphrase")
+	ErrSecretNotFound    = errors.New("secret not found")
+)
+
+// Secrets is the store the configs of this user keep their credentials in
+var Secrets = &SecretStore{}
+
+// SecretStore keeps credentials in ~/XTunnel/secrets.json, encrypted with AES-GCM under a key derived
+// from the master passphrase by argon2id. Only the key is kept in memory, the file is read on every use
+// so secrets added by another xtunnel process are seen, changes hold the lock file next to it.
+type SecretStore struct {
+	mu   sync.Mutex
+	key  []byte
+	lock storeLock
+}
+
+type secretFile struct {
+	Version int       `json:"version"`
+	KDF     kdfParams `json:"kdf"`
+	// Check is a known value sealed with the key, it tells a wrong passphrase from a damaged secret
+	Check   string            `json:"check"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    string `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+func (p kdfParams) key(passphrase string) ([]byte, error) {
+	if p.Name != "argon2id" {
+		return nil, fmt.Errorf("unsupported kdf: %s", p.Name)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kdf salt: %w", err)
+	}
+	return argon2.IDKey([]byte(passphrase), salt, p.Time, p.Memory, p.Threads, 32), nil
+}
+
+// Exists tells whether a master passphrase was set up already
+func (s *SecretStore) Exists(ctx context.Context) bool {
+	path, err := secretStorePath(ctx)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func (s *SecretStore) Locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.key == nil
+}
+
+// Unlock derives the key from passphrase, the store is created with it when there is none yet
+func (s *SecretStore) Unlock(ctx context.Context, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("master passphrase is empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := secretStorePath(ctx)
+	if err != nil {
+		return err
+	}
+	// two processes setting up the store must not each write a file with their own passphrase
+	unlock, err := s.locked(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := readSecretFile(ctx, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.create(ctx, path, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := file.KDF.key(passphrase)
+	if err != nil {
+		return err
+	}
+	if check, err := unseal(key, "check", file.Check); err != nil || check != secretCheck {
+		return ErrWrongPassphrase
+	}
+
+	s.key = key
+	logger.Info(ctx, "secret store unlocked", g.Map{"secrets": len(file.Secrets)})
+	return nil
+}
+
+// UnlockFromEnv unlocks with MasterPassphraseEnv, the store stays locked when it is not set
+func (s *SecretStore) UnlockFromEnv(ctx context.Context) error {
+	passphrase := os.Getenv(MasterPassphraseEnv)
+	if passphrase == "" {
+		return nil
+	}
+	return s.Unlock(ctx, passphrase)
+}
+
+func (s *SecretStore) create(ctx context.Context, path string, passphrase string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	file := &secretFile{
+		Version: secretFileVersion,
+		KDF:     kdfParams{Name: "argon2id", Salt: base64.StdEncoding.EncodeToString(salt), Time: 3, Memory: 64 * 1024, Threads: 4},
+		Secrets: map[string]string{},
+	}
+
+	key, err := file.KDF.key(passphrase)
+	if err != nil {
+		return err
+	}
+	if file.Check, err = seal(key, "check", secretCheck); err != nil {
+		return err
+	}
+	if err := writeSecretFile(ctx, path, file); err != nil {
+		return err
+	}
+
+	s.key = key
+	logger.Info(ctx, "secret store created", g.Map{"path": path})
+	return nil
+}
+
+// Put encrypts value and returns the reference a config keeps in its place
+func (s *SecretStore) Put(ctx context.Context, value string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil {
+		return "", ErrSecretStoreLocked
+	}
+
+	path, err := secretStorePath(ctx)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := s.locked(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	file, err := readSecretFile(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	ref := hex.EncodeToString(id)
+
+	// the id is authenticated along with the value, a ciphertext moved to another id does not open
+	if file.Secrets[ref], err = seal(s.key, ref, value); err != nil {
+		return "", err
+	}
+	if err := writeSecretFile(ctx, path, file); err != nil {
+		return "", err
+	}
+
+	return secretRefPrefix + ref, nil
+}
+
+func (s *SecretStore) Get(ctx context.Context, ref string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil {
+		return "", ErrSecretStoreLocked
+	}
+
+	path, err := secretStorePath(ctx)
+	if err != nil {
+		return "", err
+	}
+	file, err := readSecretFile(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	id := strings.TrimPrefix(ref, secretRefPrefix)
+	sealed, ok := file.Secrets[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, id)
+	}
+
+	value, err := unseal(s.key, id, sealed)
+	if err != nil {
+		logger.Error(ctx, "secret decrypt error", g.Map{"id": id, "error": err.Error()})
+		return "", fmt.Errorf("secret %s cannot be decrypted", id)
+	}
+	return value, nil
+}
+
+// Delete drops the secrets behind refs, it does not need the key
+func (s *SecretStore) Delete(ctx context.Context, refs ...string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := secretStorePath(ctx)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.locked(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := readSecretFile(ctx, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		delete(file.Secrets, strings.TrimPrefix(ref, secretRefPrefix))
+	}
+	return writeSecretFile(ctx, path, file)
+}
+
+// locked takes the lock file of the secret store for a read-modify-write, the cli and the window are
+// separate processes which may change it at the same time and one would drop the secret of the other
+func (s *SecretStore) locked(ctx context.Context, path string) (func(), error) {
+	return s.lock.lock(ctx, filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock"))
+}
+
+// IsSecretRef tells a reference to the secret store from a credential kept as is
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefPrefix)
+}
+
+func seal(key []byte, id string, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func unseal(key []byte, id string, value string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("sealed value is too short")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func readSecretFile(ctx context.Context, path string) (*secretFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &secretFile{}
+	if err := json.Unmarshal(content, file); err != nil {
+		logger.Error(ctx, "secret store unmarshal error", g.Map{"path": path, "error": err.Error()})
+		return nil, fmt.Errorf("secret store is damaged: %w", err)
+	}
+	if file.Version > secretFileVersion {
+		return nil, fmt.Errorf("secret store version %d is not supported", file.Version)
+	}
+	if file.Secrets == nil {
+		file.Secrets = map[string]string{}
+	}
+	return file, nil
+}
+
+func writeSecretFile(ctx context.Context, path string, file *secretFile) error {
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, content, 0600); err != nil {
+		logger.Error(ctx, "secret store write error", g.Map{"path": path, "error": err.Error()})
+		return fmt.Errorf("secret store write error")
+	}
+	return nil
+}
+
+// secretStorePath is ~/XTunnel/secrets.json
+func secretStorePath(ctx context.Context) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logger.Error(ctx, "cannot find home dir", g.Map{"error": err.Error()})
+		return "", fmt.Errorf("cannot find home dir")
+	}
+
+	dir := filepath.Join(homeDir, "XTunnel")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Error(ctx, "secret store mkdir error", g.Map{"path": dir, "error": err.Error()})
+		return "", fmt.Errorf("secret store mkdir error")
+	}
+	return filepath.Join(dir, "secrets.json"), nil
+}
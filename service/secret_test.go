@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a store of its own stands in for another process, only the lock file keeps their writes apart
+func TestSecretStoreConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	unlockSecrets(t)
+	other := &SecretStore{key: Secrets.key}
+
+	const puts = 100
+	refs := make([]string, 2*puts)
+	var wg sync.WaitGroup
+	for i, store := range []*SecretStore{Secrets, other} {
+		for j := 0; j < puts; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ref, err := store.Put(ctx, "value")
+				if err != nil {
+					t.Errorf("put: %s", err)
+				}
+				refs[i*puts+j] = ref
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, ref := range refs {
+		if _, err := Secrets.Get(ctx, ref); err != nil {
+			t.Errorf("%s was lost: %s", ref, err)
+		}
+	}
+}
+
+func TestSecretStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	unlockSecrets(t)
+
+	ref, err := Secrets.Put(ctx, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSecretRef(ref) {
+		t.Fatalf("put returned %q, not a secret ref", ref)
+	}
+	if value, err := Secrets.Get(ctx, ref); err != nil || value != "hunter2" {
+		t.Fatalf("get = %q, %v", value, err)
+	}
+
+	path, err := secretStorePath(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(mustRead(t, path)), "hunter2") {
+		t.Error("the secret store keeps the value in plaintext")
+	}
+
+	if err := Secrets.Delete(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Secrets.Get(ctx, ref); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("get after delete: %v, want %s", err, ErrSecretNotFound)
+	}
+}
+
+func TestSecretStoreWrongPassphrase(t *testing.T) {
+	ctx := context.Background()
+	unlockSecrets(t)
+	ref, err := Secrets.Put(ctx, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := &SecretStore{}
+	if err := other.Unlock(ctx, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("unlock: %v, want %s", err, ErrWrongPassphrase)
+	}
+	if !other.Locked() {
+		t.Fatal("a wrong passphrase unlocked the store")
+	}
+	if _, err := other.Get(ctx, ref); !errors.Is(err, ErrSecretStoreLocked) {
+		t.Errorf("get: %v, want %s", err, ErrSecretStoreLocked)
+	}
+}
+
+// a config file from before the secret store keeps its password in plaintext until the store is unlocked
+func TestLoadConfigSealsPlaintextSecrets(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig("plain")
+	config.Identifier = "plain"
+	config.AuthType = AuthTypePassword
+	config.Password = "hunter2"
+	config.SchemaVersion = ConfigSchemaVersion
+	config.FileName = "plain.json"
+	content, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configPath, err := config.EnsureDir(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(configPath, config.FileName)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	load := func() *ConfigFile {
+		t.Helper()
+
+		configs, err := (&DirConfigStore{}).Load(ctx)
+		if err != nil {
+			t.Fatalf("load: %s", err)
+		}
+		for _, loaded := range configs {
+			if loaded.Identifier == "plain" {
+				return loaded
+			}
+		}
+		t.Fatal("the config is not loaded")
+		return nil
+	}
+
+	if loaded := load(); loaded.Password != "hunter2" {
+		t.Fatalf("password = %q while the store is locked, want the plaintext", loaded.Password)
+	}
+
+	unlockSecrets(t)
+	loaded := load()
+	if !IsSecretRef(loaded.Password) {
+		t.Fatalf("password = %q, want a secret ref", loaded.Password)
+	}
+	if strings.Contains(string(mustRead(t, path)), "hunter2") {
+		t.Error("the config file keeps the password in plaintext")
+	}
+	if value, err := Secrets.Get(ctx, loaded.Password); err != nil || value != "hunter2" {
+		t.Errorf("sealed password = %q, %v", value, err)
+	}
+}
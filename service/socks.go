@@ -0,0 +1,179 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion     = 0x05
+	socksAuthVersion = 0x01
+
+	socksMethodNone         = 0x00
+	socksMethodPassword     = 0x02
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded        = 0x00
+	socksReplyGeneralFailure   = 0x01
+	socksReplyHostUnreachable  = 0x04
+	socksReplyCmdNotSupported  = 0x07
+	socksReplyAddrNotSupported = 0x08
+
+	socksAuthStatusSucceeded = 0x00
+	socksAuthStatusFailed    = 0x01
+
+	socksHandshakeTimeout = 30 * time.Second
+)
+
+// socksHandshake negotiates a SOCKS5 CONNECT request on conn and returns the requested destination,
+// the caller must answer with socksReply once the destination is dialed.
+func socksHandshake(conn net.Conn, username, password string) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(socksHandshakeTimeout)); err != nil {
+		return "", err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("socks read greeting error: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("socks version %d not supported", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", fmt.Errorf("socks read methods error: %w", err)
+	}
+
+	method := byte(socksMethodNone)
+	if username != "" || password != "" {
+		method = socksMethodPassword
+	}
+
+	if !slices.Contains(methods, method) {
+		conn.Write([]byte{socksVersion, socksMethodNoAcceptable})
+		return "", fmt.Errorf("socks client offers no acceptable auth method")
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+
+	if method == socksMethodPassword {
+		if err := socksAuthenticate(conn, username, password); err != nil {
+			return "", err
+		}
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", fmt.Errorf("socks read request error: %w", err)
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("socks version %d not supported", request[0])
+	}
+	if request[1] != socksCmdConnect {
+		socksReply(conn, socksReplyCmdNotSupported)
+		return "", fmt.Errorf("socks command %d not supported", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("socks read address error: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return "", fmt.Errorf("socks read address error: %w", err)
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", fmt.Errorf("socks read address error: %w", err)
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksReplyAddrNotSupported)
+		return "", fmt.Errorf("socks address type %d not supported", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", fmt.Errorf("socks read port error: %w", err)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksAuthenticate runs the username/password sub-negotiation of RFC 1929
+func socksAuthenticate(conn net.Conn, username, password string) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("socks read auth error: %w", err)
+	}
+	if header[0] != socksAuthVersion {
+		return fmt.Errorf("socks auth version %d not supported", header[0])
+	}
+
+	user := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return fmt.Errorf("socks read auth error: %w", err)
+	}
+
+	size := make([]byte, 1)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return fmt.Errorf("socks read auth error: %w", err)
+	}
+	pass := make([]byte, size[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return fmt.Errorf("socks read auth error: %w", err)
+	}
+
+	userOk := subtle.ConstantTimeCompare(user, []byte(username)) == 1
+	passOk := subtle.ConstantTimeCompare(pass, []byte(password)) == 1
+	if !userOk || !passOk {
+		conn.Write([]byte{socksAuthVersion, socksAuthStatusFailed})
+		return fmt.Errorf("socks auth failed for user %q", string(user))
+	}
+
+	_, err := conn.Write([]byte{socksAuthVersion, socksAuthStatusSucceeded})
+	return err
+}
+
+// socksDialReply is the reply to a CONNECT whose dial through the ssh connection failed, only a target
+// the server could not reach is reported as unreachable, a lost or stopping connection is a general failure
+func socksDialReply(err error) byte {
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) && channelErr.Reason == ssh.ConnectionFailed {
+		return socksReplyHostUnreachable
+	}
+	return socksReplyGeneralFailure
+}
+
+func socksReply(conn net.Conn, reply byte) error {
+	// the bound address is not meaningful for a tunneled connection, answer with 0.0.0.0:0
+	_, err := conn.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
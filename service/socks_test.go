@@ -0,0 +1,22 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestSocksDialReply(t *testing.T) {
+	refused := fmt.Errorf("dial: %w", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "connect failed"})
+	if reply := socksDialReply(refused); reply != socksReplyHostUnreachable {
+		t.Errorf("target not reachable from the server: reply %#x", reply)
+	}
+
+	prohibited := &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "administratively prohibited"}
+	for _, err := range []error{prohibited, errors.New("ssh client not ready")} {
+		if reply := socksDialReply(err); reply != socksReplyGeneralFailure {
+			t.Errorf("%s: reply %#x, want a general failure", err, reply)
+		}
+	}
+}
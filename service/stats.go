@@ -0,0 +1,124 @@
+package service
+
+import (
+	"cmp"
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// TunnelStats is a snapshot of the counters of one tunnel, they start from zero on every start
+type TunnelStats struct {
+	Status    TunnelStatus
+	StartedAt time.Time
+	Uptime    time.Duration
+
+	// BytesSent flows from the accepted connections to their targets, BytesReceived the other way
+	BytesSent     int64
+	BytesReceived int64
+
+	ActiveConns   int
+	TotalAccepted int64
+	DialFailures  int64
+	Connections   []ConnStats
+
+	// LastError is the latest error reported by the tunnel, empty when there was none since the start
+	LastError   string
+	LastErrorAt time.Time
+}
+
+type ConnStats struct {
+	ID            uint64
+	Client        string
+	Target        string
+	OpenedAt      time.Time
+	BytesSent     int64
+	BytesReceived int64
+}
+
+type connStats struct {
+	id       uint64
+	client   string
+	target   string
+	openedAt time.Time
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (t *Tunnel) Stats() TunnelStats {
+	t.mu.RLock()
+	stats := TunnelStats{Status: t.status, StartedAt: t.startedAt}
+	if t.status == StatusRunning || t.status == StatusReconnecting {
+		stats.Uptime = time.Since(t.startedAt)
+	}
+	t.mu.RUnlock()
+
+	stats.BytesSent = t.bytesSent.Load()
+	stats.BytesReceived = t.bytesReceived.Load()
+	stats.TotalAccepted = t.accepted.Load()
+	stats.DialFailures = t.dialFailures.Load()
+
+	t.statsMu.Lock()
+	if t.lastErr != nil {
+		stats.LastError = t.lastErr.Error()
+		stats.LastErrorAt = t.lastErrAt
+	}
+	for _, conn := range t.conns {
+		stats.Connections = append(stats.Connections, ConnStats{
+			ID:            conn.id,
+			Client:        conn.client,
+			Target:        conn.target,
+			OpenedAt:      conn.openedAt,
+			BytesSent:     conn.sent.Load(),
+			BytesReceived: conn.received.Load(),
+		})
+	}
+	t.statsMu.Unlock()
+
+	slices.SortFunc(stats.Connections, func(a, b ConnStats) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	stats.ActiveConns = len(stats.Connections)
+
+	return stats
+}
+
+func (t *Tunnel) trackConn(client, target string) *connStats {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	t.nextConnID++
+	conn := &connStats{id: t.nextConnID, client: client, target: target, openedAt: time.Now()}
+	t.conns[conn.id] = conn
+	return conn
+}
+
+func (t *Tunnel) recordError(err error, at time.Time) {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	t.lastErr = err
+	t.lastErrAt = at
+}
+
+func (t *Tunnel) untrackConn(conn *connStats) {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	delete(t.conns, conn.id)
+}
+
+// countingWriter adds every written chunk to its counters, so totals move while a connection is still open
+type countingWriter struct {
+	w        io.Writer
+	counters []*atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	for _, counter := range c.counters {
+		counter.Add(int64(n))
+	}
+	return n, err
+}
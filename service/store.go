@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"xtunnel/logger"
+)
+
+// ConfigStoreEnv points at a single .yaml, .yml or .toml file to keep the configs in
+const ConfigStoreEnv = "XTUNNEL_CONFIG"
+
+// configStoreFiles are the single files in ~/XTunnel picked up without ConfigStoreEnv, the first one that exists wins
+var configStoreFiles = []string{"tunnels.yaml", "tunnels.yml", "tunnels.toml"}
+
+// ConfigStore is where the tunnel configs are kept, configs are told apart by their Identifier
+type ConfigStore interface {
+	Load(ctx context.Context) ([]*ConfigFile, error)
+	// Create stores a new config, it gets an identifier when it has none
+	Create(ctx context.Context, config *ConfigFile) error
+	Update(ctx context.Context, config *ConfigFile) error
+	Delete(ctx context.Context, config *ConfigFile) error
+	// Location is the dir or the file the configs are kept in
+	Location(ctx context.Context) (string, error)
+}
+
+var (
+	storesMu   sync.Mutex
+	fileStores = make(map[string]*FileConfigStore)
+	dirStore   = &DirConfigStore{}
+)
+
+// DefaultConfigStore is the single file of ConfigStoreEnv or ~/XTunnel/tunnels.yaml when there is one,
+// otherwise one json file per tunnel in ~/XTunnel/.config. It is looked up on every call so a single file
+// created while xtunnel runs takes over on the next load, the stores themselves are shared.
+func DefaultConfigStore() ConfigStore {
+	if path := os.Getenv(ConfigStoreEnv); path != "" {
+		return OpenFileConfigStore(path)
+	}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		for _, name := range configStoreFiles {
+			path := filepath.Join(homeDir, "XTunnel", name)
+			if _, err := os.Stat(path); err == nil {
+				return OpenFileConfigStore(path)
+			}
+		}
+	}
+	return dirStore
+}
+
+// OpenFileConfigStore returns the store of the single file at path, every caller of the process shares it
+func OpenFileConfigStore(path string) *FileConfigStore {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	store, ok := fileStores[path]
+	if !ok {
+		store = &FileConfigStore{path: path}
+		fileStores[path] = store
+	}
+	return store
+}
+
+// storeLock serializes the read-modify-write of a store, the mutex within the process and the lock file
+// between processes, as the cli may edit while the window or the daemon reloads. The secret store uses it too.
+type storeLock struct {
+	mu sync.Mutex
+}
+
+func (l *storeLock) lock(ctx context.Context, path string) (func(), error) {
+	l.mu.Lock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err == nil {
+		if err = lockFile(file); err != nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		l.mu.Unlock()
+		logger.Error(ctx, "store lock error", g.Map{"path": path, "error": err.Error()})
+		return nil, fmt.Errorf("store lock error: %s", filepath.Base(path))
+	}
+
+	return func() {
+		unlockFile(file)
+		file.Close()
+		l.mu.Unlock()
+	}, nil
+}
+
+func newIdentifier() string {
+	return fmt.Sprintf("%d", time.Now().UnixMicro())
+}
+
+// DirConfigStore keeps every config in a json file of its own, see ConfigFile for the file handling
+type DirConfigStore struct {
+	lock storeLock
+}
+
+// locked runs fn under the lock of the config dir, loading writes back too when it migrates a file
+func (s *DirConfigStore) locked(ctx context.Context, fn func() error) error {
+	configPath, err := s.Location(ctx)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lock.lock(ctx, filepath.Join(configPath, ".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+func (s *DirConfigStore) Load(ctx context.Context) ([]*ConfigFile, error) {
+	var configs []*ConfigFile
+	err := s.locked(ctx, func() error {
+		var err error
+		configs, err = (&ConfigFile{}).LoadConfigFile(ctx)
+		return err
+	})
+	return configs, err
+}
+
+func (s *DirConfigStore) Create(ctx context.Context, config *ConfigFile) error {
+	return s.locked(ctx, func() error {
+		if config.Identifier == "" {
+			config.Identifier = newIdentifier()
+		}
+		return config.SaveConfigFile(ctx)
+	})
+}
+
+func (s *DirConfigStore) Update(ctx context.Context, config *ConfigFile) error {
+	return s.locked(ctx, func() error {
+		return config.UpdateConfigFile(ctx)
+	})
+}
+
+func (s *DirConfigStore) Delete(ctx context.Context, config *ConfigFile) error {
+	return s.locked(ctx, func() error {
+		return config.DeleteConfigFile(ctx)
+	})
+}
+
+func (s *DirConfigStore) Location(ctx context.Context) (string, error) {
+	return (&ConfigFile{}).EnsureDir(ctx)
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/encoding/gtoml"
+	"github.com/gogf/gf/v2/encoding/gyaml"
+	"github.com/gogf/gf/v2/frame/g"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"xtunnel/logger"
+)
+
+// FileConfigStore keeps every config in one yaml or toml file, the format follows the extension:
+//
+//	schema_version: 1
+//	tunnels:
+//	  - identifier: db
+//	    config_name: db
+//	    server_ip: bastion.example.com
+//	    ...
+//
+// Empty fields are left out and numbers may be written unquoted. Tunnels written by hand without an
+// identifier get one on the first load, it is written back so it stays the same afterwards.
+type FileConfigStore struct {
+	path string
+	lock storeLock
+}
+
+type configStoreFile struct {
+	SchemaVersion int           `json:"schema_version"`
+	Tunnels       []*ConfigFile `json:"tunnels"`
+}
+
+func (s *FileConfigStore) Location(ctx context.Context) (string, error) {
+	return s.path, nil
+}
+
+// Load returns the configs of the file, a file which cannot be read is reported as a ConfigLoadError
+func (s *FileConfigStore) Load(ctx context.Context) ([]*ConfigFile, error) {
+	unlock, err := s.locked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	configs, changed, err := s.read(ctx)
+	if err != nil {
+		loadErr := &ConfigLoadError{}
+		loadErr.add(s.path, err)
+		return make([]*ConfigFile, 0), loadErr
+	}
+	if changed {
+		if err := s.write(ctx, configs, true); err != nil {
+			logger.Error(ctx, "config store migrate error", g.Map{"path": s.path, "error": err.Error()})
+		}
+	}
+
+	// plaintext written by hand is moved into the secret store like the one of older config files,
+	// the plaintext version is not kept as a backup
+	sealed := false
+	for _, config := range configs {
+		if !config.HasPlaintextSecrets() || Secrets.Locked() {
+			continue
+		}
+		if err := config.SealSecrets(ctx); err != nil {
+			logger.Error(ctx, "config secrets migrate error", g.Map{"identifier": config.Identifier, "error": err.Error()})
+			continue
+		}
+		sealed = true
+	}
+	if sealed {
+		if err := s.write(ctx, configs, false); err != nil {
+			logger.Error(ctx, "config secrets migrate error", g.Map{"path": s.path, "error": err.Error()})
+		} else {
+			logger.Info(ctx, "config secrets migrated", g.Map{"path": s.path})
+		}
+	}
+
+	return configs, nil
+}
+
+func (s *FileConfigStore) Create(ctx context.Context, config *ConfigFile) error {
+	unlock, err := s.locked(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	configs, _, err := s.read(ctx)
+	if err != nil {
+		return fmt.Errorf("config store read error: %w", err)
+	}
+
+	if config.Identifier == "" {
+		config.Identifier = newIdentifier()
+	}
+	if index(configs, config.Identifier) >= 0 {
+		return fmt.Errorf("tunnel identifier %q is taken", config.Identifier)
+	}
+	if config.ConfigName == "" {
+		config.ConfigName = fmt.Sprintf("%s:%s", config.RemoteIP, config.RemotePort)
+	}
+	if err := config.SealSecrets(ctx); err != nil {
+		return err
+	}
+
+	if err := s.write(ctx, append(configs, config), true); err != nil {
+		return err
+	}
+	logger.Info(ctx, "config saved", g.Map{"path": s.path, "identifier": config.Identifier})
+	return nil
+}
+
+func (s *FileConfigStore) Update(ctx context.Context, config *ConfigFile) error {
+	unlock, err := s.locked(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	configs, _, err := s.read(ctx)
+	if err != nil {
+		return fmt.Errorf("config store read error: %w", err)
+	}
+
+	i := index(configs, config.Identifier)
+	if i < 0 {
+		return fmt.Errorf("tunnel %q not found", config.Identifier)
+	}
+	if err := config.SealSecrets(ctx); err != nil {
+		return err
+	}
+
+	configs[i] = config
+	if err := s.write(ctx, configs, true); err != nil {
+		return err
+	}
+	logger.Info(ctx, "config updated", g.Map{"path": s.path, "identifier": config.Identifier})
+	return nil
+}
+
+func (s *FileConfigStore) Delete(ctx context.Context, config *ConfigFile) error {
+	unlock, err := s.locked(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	configs, _, err := s.read(ctx)
+	if err != nil {
+		return fmt.Errorf("config store read error: %w", err)
+	}
+
+	i := index(configs, config.Identifier)
+	if i < 0 {
+		return fmt.Errorf("tunnel %q not found", config.Identifier)
+	}
+
+	configs = append(configs[:i], configs[i+1:]...)
+	if err := s.write(ctx, configs, true); err != nil {
+		return err
+	}
+	logger.Info(ctx, "config deleted", g.Map{"path": s.path, "identifier": config.Identifier})
+	return nil
+}
+
+// read decodes the file, changed tells that it was migrated or identifiers were added and should be written back.
+// A missing file is an empty store.
+func (s *FileConfigStore) read(ctx context.Context) ([]*ConfigFile, bool, error) {
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make([]*ConfigFile, 0), false, nil
+	}
+	if err != nil {
+		logger.Error(ctx, "config store read error", g.Map{"path": s.path, "error": err.Error()})
+		return nil, false, err
+	}
+
+	configs, changed, err := s.decode(content)
+	if err != nil {
+		logger.Error(ctx, "config store decode error", g.Map{"path": s.path, "error": err.Error()})
+		return nil, false, err
+	}
+	return configs, changed, nil
+}
+
+// decode reads the content of the file or of its backup, which are in the format of the file
+func (s *FileConfigStore) decode(content []byte) ([]*ConfigFile, bool, error) {
+	var (
+		jsonContent []byte
+		err         error
+	)
+	switch s.format() {
+	case "toml":
+		jsonContent, err = gtoml.ToJson(content)
+	default:
+		jsonContent, err = gyaml.ToJson(content)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	raw := make(map[string]any)
+	if err := json.Unmarshal(jsonContent, &raw); err != nil {
+		return nil, false, err
+	}
+
+	// the single file did not exist before schema version 1, a hand written one without a version is current
+	version, err := schemaVersion(raw, ConfigSchemaVersion)
+	if err != nil {
+		return nil, false, err
+	}
+	changed := version != ConfigSchemaVersion
+
+	tunnels, _ := raw["tunnels"].([]any)
+	for _, tunnel := range tunnels {
+		tunnel, ok := tunnel.(map[string]any)
+		if !ok {
+			return nil, false, fmt.Errorf("tunnels must be a list of tables")
+		}
+		stringifyScalars(tunnel)
+		if err := upgradeConfig(tunnel, version); err != nil {
+			return nil, false, err
+		}
+	}
+
+	normalized, err := json.Marshal(map[string]any{"tunnels": tunnels})
+	if err != nil {
+		return nil, false, err
+	}
+	file := &configStoreFile{}
+	if err := json.Unmarshal(normalized, file); err != nil {
+		return nil, false, err
+	}
+
+	configs := make([]*ConfigFile, 0, len(file.Tunnels))
+	seen := make(map[string]bool)
+	for _, config := range file.Tunnels {
+		if config == nil {
+			continue
+		}
+		if config.Identifier == "" {
+			config.Identifier = newIdentifier()
+			for seen[config.Identifier] {
+				config.Identifier = newIdentifier()
+			}
+			changed = true
+		}
+		if seen[config.Identifier] {
+			return nil, false, fmt.Errorf("tunnel identifier %q is used twice", config.Identifier)
+		}
+		seen[config.Identifier] = true
+
+		config.SchemaVersion = ConfigSchemaVersion
+		config.FileName = ""
+		configs = append(configs, config)
+	}
+	return configs, changed, nil
+}
+
+// write replaces the file with configs, the previous version is kept as a .bak when backup is set.
+// Secrets are deleted once neither the file nor its backup references them, so the ones of a removed
+// or changed tunnel stay until the backup holding them is replaced.
+func (s *FileConfigStore) write(ctx context.Context, configs []*ConfigFile, backup bool) error {
+	tunnels := make([]any, 0, len(configs))
+	for _, config := range configs {
+		content, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		tunnel := make(map[string]any)
+		if err := json.Unmarshal(content, &tunnel); err != nil {
+			return err
+		}
+		// the version is the one of the file and the name of a file of its own means nothing here
+		delete(tunnel, "schema_version")
+		delete(tunnel, "file_name")
+		tunnels = append(tunnels, dropEmpty(tunnel))
+	}
+
+	doc := map[string]any{"schema_version": ConfigSchemaVersion, "tunnels": tunnels}
+	var (
+		content []byte
+		err     error
+	)
+	switch s.format() {
+	case "toml":
+		content, err = gtoml.Encode(doc)
+	default:
+		content, err = gyaml.Encode(doc)
+	}
+	if err != nil {
+		logger.Error(ctx, "config store encode error", g.Map{"path": s.path, "error": err.Error()})
+		return fmt.Errorf("config store encode error")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		logger.Error(ctx, "config store mkdir error", g.Map{"path": s.path, "error": err.Error()})
+		return fmt.Errorf("config store mkdir error")
+	}
+	replaced := s.storedRefs(s.path, backupPath(s.path))
+	if backup {
+		if previous, err := os.ReadFile(s.path); err == nil {
+			if err := writeFileAtomic(backupPath(s.path), previous, 0600); err != nil {
+				logger.Error(ctx, "config store backup error", g.Map{"path": s.path, "error": err.Error()})
+				return fmt.Errorf("config store backup error")
+			}
+		}
+	}
+
+	if err := writeFileAtomic(s.path, content, 0600); err != nil {
+		logger.Error(ctx, "config store write error", g.Map{"path": s.path, "error": err.Error()})
+		return fmt.Errorf("config store write error")
+	}
+
+	used := s.storedRefs(backupPath(s.path))
+	for _, config := range configs {
+		used = append(used, config.secretRefs()...)
+	}
+	if err := Secrets.Delete(ctx, unusedRefs(replaced, used)...); err != nil {
+		logger.Error(ctx, "config secrets delete error", g.Map{"path": s.path, "error": err.Error()})
+	}
+	return nil
+}
+
+// storedRefs returns the secret refs in the files at paths, files which cannot be read are skipped
+func (s *FileConfigStore) storedRefs(paths ...string) []string {
+	refs := make([]string, 0)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		configs, _, err := s.decode(content)
+		if err != nil {
+			continue
+		}
+		for _, config := range configs {
+			refs = append(refs, config.secretRefs()...)
+		}
+	}
+	return refs
+}
+
+// locked takes the lock file next to the store, Load takes it too as it may write back
+func (s *FileConfigStore) locked(ctx context.Context) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		logger.Error(ctx, "config store mkdir error", g.Map{"path": s.path, "error": err.Error()})
+		return nil, fmt.Errorf("config store mkdir error")
+	}
+	return s.lock.lock(ctx, filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".lock"))
+}
+
+func (s *FileConfigStore) format() string {
+	if strings.EqualFold(filepath.Ext(s.path), ".toml") {
+		return "toml"
+	}
+	return "yaml"
+}
+
+func index(configs []*ConfigFile, identifier string) int {
+	for i, config := range configs {
+		if config.Identifier == identifier {
+			return i
+		}
+	}
+	return -1
+}
+
+// typedKeys are the fields of a config which are no string, they keep the type they were written with
+var typedKeys = map[string]bool{"autostart": true, "schema_version": true}
+
+// stringifyScalars turns the unquoted numbers of a hand written file into the strings the configs keep
+func stringifyScalars(raw map[string]any) {
+	for key, value := range raw {
+		if typedKeys[key] {
+			continue
+		}
+		switch value := value.(type) {
+		case float64:
+			raw[key] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			raw[key] = strconv.FormatBool(value)
+		case []any:
+			for _, item := range value {
+				if item, ok := item.(map[string]any); ok {
+					stringifyScalars(item)
+				}
+			}
+		}
+	}
+}
+
+// dropEmpty leaves out the fields a hand written file would not have
+func dropEmpty(raw map[string]any) map[string]any {
+	for key, value := range raw {
+		switch value := value.(type) {
+		case nil:
+			delete(raw, key)
+		case string:
+			if value == "" {
+				delete(raw, key)
+			}
+		case bool:
+			if !value {
+				delete(raw, key)
+			}
+		case []any:
+			if len(value) == 0 {
+				delete(raw, key)
+				continue
+			}
+			for _, item := range value {
+				if item, ok := item.(map[string]any); ok {
+					dropEmpty(item)
+				}
+			}
+		}
+	}
+	return raw
+}
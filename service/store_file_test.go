@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testConfig(name string) *ConfigFile {
+	return &ConfigFile{
+		ConfigName: name,
+		ServerIP:   "127.0.0.1",
+		ServerPort: "22",
+		UserName:   "u",
+		AuthType:   AuthTypeAgent,
+		Mode:       ForwardModeLocal,
+		RemoteIP:   "127.0.0.1",
+		RemotePort: "5432",
+	}
+}
+
+func TestOpenFileConfigStoreIsShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnels.yaml")
+	if OpenFileConfigStore(path) != OpenFileConfigStore(path) {
+		t.Fatal("two stores for the same file")
+	}
+
+	t.Setenv(ConfigStoreEnv, path)
+	if DefaultConfigStore() != DefaultConfigStore() {
+		t.Fatal("DefaultConfigStore returns a new store on every call")
+	}
+}
+
+func TestFileConfigStoreConcurrentUpdateLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tunnels.yaml")
+	store := OpenFileConfigStore(path)
+	// a store of its own stands in for another process, only the lock file serializes it with the shared one
+	other := &FileConfigStore{path: path}
+
+	const tunnels = 16
+	for i := 0; i < tunnels; i++ {
+		if err := store.Create(ctx, testConfig(fmt.Sprintf("t%d", i))); err != nil {
+			t.Fatalf("create: %s", err)
+		}
+	}
+	// a hand written tunnel without an identifier makes the first load write back
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = append(content, []byte("    - config_name: hand\n      server_ip: 127.0.0.1\n      server_port: 22\n      user_name: u\n      auth_type: agent\n      remote_ip: 127.0.0.1\n      remote_port: 80\n")...)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		s := ConfigStore(store)
+		if i%2 == 1 {
+			s = other
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			config.ConfigName += "-updated"
+			if err := s.Update(ctx, config); err != nil {
+				t.Errorf("update %s: %s", config.Identifier, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.Load(ctx); err != nil {
+				t.Errorf("load: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	configs, err = store.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if len(configs) != tunnels+1 {
+		t.Fatalf("%d tunnels after the updates, want %d", len(configs), tunnels+1)
+	}
+	for _, config := range configs {
+		if !strings.HasSuffix(config.ConfigName, "-updated") {
+			t.Errorf("update of %s (%s) was lost", config.Identifier, config.ConfigName)
+		}
+	}
+}
+
+func TestFileConfigStoreLoadsHandWrittenFile(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"tunnels.yaml": `schema_version: 1
+tunnels:
+  - config_name: db
+    schema_version: 1
+    server_ip: bastion.example.com
+    server_port: 22
+    user_name: u
+    auth_type: agent
+    remote_ip: 10.0.0.5
+    remote_port: 5432
+    local_port: 15432
+    autostart: true
+    jump_hosts:
+      - ip: jump.example.com
+        port: 2222
+        user_name: j
+        auth_type: agent
+`,
+		"tunnels.toml": `schema_version = 1
+
+[[tunnels]]
+config_name = "db"
+schema_version = 1
+server_ip = "bastion.example.com"
+server_port = 22
+user_name = "u"
+auth_type = "agent"
+remote_ip = "10.0.0.5"
+remote_port = 5432
+local_port = 15432
+autostart = true
+
+[[tunnels.jump_hosts]]
+ip = "jump.example.com"
+port = 2222
+user_name = "j"
+auth_type = "agent"
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			configs, err := OpenFileConfigStore(path).Load(ctx)
+			if err != nil {
+				t.Fatalf("load: %s", err)
+			}
+			if len(configs) != 1 {
+				t.Fatalf("%d configs", len(configs))
+			}
+			config := configs[0]
+			if config.Identifier == "" {
+				t.Error("no identifier assigned")
+			}
+			if config.ServerPort != "22" || config.RemotePort != "5432" || config.LocalPort != "15432" || !config.AutoStart {
+				t.Errorf("server port %q, remote port %q, local port %q, autostart %v", config.ServerPort, config.RemotePort, config.LocalPort, config.AutoStart)
+			}
+			if len(config.JumpHosts) != 1 || config.JumpHosts[0].Port != "2222" {
+				t.Errorf("jump hosts %+v", config.JumpHosts)
+			}
+		})
+	}
+}
+
+func TestFileConfigStoreRoundTrip(t *testing.T) {
+	for _, name := range []string{"tunnels.yaml", "tunnels.toml"} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := OpenFileConfigStore(filepath.Join(t.TempDir(), name))
+
+			load := func() []*ConfigFile {
+				t.Helper()
+
+				configs, err := store.Load(ctx)
+				if err != nil {
+					t.Fatalf("load: %s", err)
+				}
+				return configs
+			}
+
+			for _, config := range []*ConfigFile{testConfig("db"), testConfig("cache")} {
+				if err := store.Create(ctx, config); err != nil {
+					t.Fatalf("create: %s", err)
+				}
+				if config.Identifier == "" {
+					t.Fatal("create left the identifier empty")
+				}
+			}
+
+			configs := load()
+			if len(configs) != 2 || configs[0].ConfigName != "db" || configs[1].ConfigName != "cache" {
+				t.Fatalf("loaded %v", configs)
+			}
+			db, cache := configs[0], configs[1]
+
+			db.RemotePort = "6543"
+			if err := store.Update(ctx, db); err != nil {
+				t.Fatalf("update: %s", err)
+			}
+			configs = load()
+			if len(configs) != 2 || configs[0].Identifier != db.Identifier || configs[0].RemotePort != "6543" {
+				t.Fatalf("after update loaded %v", configs)
+			}
+
+			if err := store.Delete(ctx, db); err != nil {
+				t.Fatalf("delete: %s", err)
+			}
+			configs = load()
+			if len(configs) != 1 || configs[0].Identifier != cache.Identifier {
+				t.Fatalf("after delete loaded %v", configs)
+			}
+		})
+	}
+}
+
+func TestDefaultConfigStore(t *testing.T) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := DefaultConfigStore().(*DirConfigStore); !ok {
+		t.Fatal("without a single file the configs are not kept one file each")
+	}
+
+	path := filepath.Join(homeDir, "XTunnel", "tunnels.yaml")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	if store := DefaultConfigStore(); store != OpenFileConfigStore(path) {
+		t.Fatalf("store = %T, want the file in ~/XTunnel", store)
+	}
+
+	env := filepath.Join(t.TempDir(), "tunnels.toml")
+	t.Setenv(ConfigStoreEnv, env)
+	if store := DefaultConfigStore(); store != OpenFileConfigStore(env) {
+		t.Fatalf("store = %T, want the file of %s", store, ConfigStoreEnv)
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// unlockSecrets unlocks the secret store of the test home, it is locked again once the test is done
+func unlockSecrets(t *testing.T) {
+	t.Helper()
+
+	if err := Secrets.Unlock(context.Background(), "test"); err != nil {
+		t.Fatalf("unlock secrets: %s", err)
+	}
+	t.Cleanup(func() {
+		Secrets.mu.Lock()
+		Secrets.key = nil
+		Secrets.mu.Unlock()
+	})
+}
+
+// checkSecrets tells which of refs must still be in the secret store
+func checkSecrets(t *testing.T, step string, kept []string, deleted []string) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, ref := range kept {
+		if _, err := Secrets.Get(ctx, ref); err != nil {
+			t.Errorf("%s: %s is gone, a backup still references it: %s", step, ref, err)
+		}
+	}
+	for _, ref := range deleted {
+		if _, err := Secrets.Get(ctx, ref); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("%s: %s is kept after the last reference to it was replaced: %v", step, ref, err)
+		}
+	}
+}
+
+// testSecretStore updates the password of a tunnel twice and deletes it, the secrets of a version
+// must stay until the backup holding it is replaced
+func testSecretStore(t *testing.T, store ConfigStore, reload func(config *ConfigFile) *ConfigFile) {
+	ctx := context.Background()
+	unlockSecrets(t)
+
+	config := testConfig("db")
+	config.AuthType = AuthTypePassword
+	config.Password = "one"
+	if err := store.Create(ctx, config); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	one := config.Password
+
+	config = reload(config)
+	config.Password = "two"
+	if err := store.Update(ctx, config); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	two := config.Password
+	checkSecrets(t, "first update", []string{one, two}, nil)
+
+	config = reload(config)
+	config.Password = "three"
+	if err := store.Update(ctx, config); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	three := config.Password
+	checkSecrets(t, "second update", []string{two, three}, []string{one})
+
+	if err := store.Delete(ctx, reload(config)); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	checkSecrets(t, "delete", nil, nil)
+}
+
+func TestFileConfigStoreKeepsSecretsOfBackup(t *testing.T) {
+	ctx := context.Background()
+	store := OpenFileConfigStore(filepath.Join(t.TempDir(), "tunnels.yaml"))
+	testSecretStore(t, store, func(config *ConfigFile) *ConfigFile {
+		configs, err := store.Load(ctx)
+		if err != nil || len(configs) != 1 {
+			t.Fatalf("load: %v, %v", configs, err)
+		}
+		return configs[0]
+	})
+
+	// the backup holds the deleted tunnel, its secret goes once the backup is replaced
+	backup, _, err := store.decode(mustRead(t, backupPath(store.path)))
+	if err != nil || len(backup) != 1 {
+		t.Fatalf("backup: %v, %v", backup, err)
+	}
+	checkSecrets(t, "delete", backup[0].secretRefs(), nil)
+	if err := store.Create(ctx, testConfig("other")); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	checkSecrets(t, "backup replaced", nil, backup[0].secretRefs())
+}
+
+func TestDirConfigStoreDeletesSecretsOfBackups(t *testing.T) {
+	store := &DirConfigStore{}
+	var refs []string
+	testSecretStore(t, store, func(config *ConfigFile) *ConfigFile {
+		refs = append(refs, config.secretRefs()...)
+		reloaded := *config
+		return &reloaded
+	})
+
+	// the .bak goes along with the file, so do the secrets only it referenced
+	checkSecrets(t, "delete", nil, refs)
+}
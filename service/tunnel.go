@@ -2,92 +2,239 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gogf/gf/v2/frame/g"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"math/rand/v2"
 	"net"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"xtunnel/logger"
 )
 
 type TunnelStatus int
 
+func (s TunnelStatus) String() string {
+	switch s {
+	case StatusStopped:
+		return "stopped"
+	case StatusStarting:
+		return "starting"
+	case StatusRunning:
+		return "running"
+	case StatusStopping:
+		return "stopping"
+	case StatusReconnecting:
+		return "reconnecting"
+	case StatusFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
 const (
 	StatusStopped TunnelStatus = iota
 	StatusStarting
 	StatusRunning
 	StatusStopping
+	// StatusReconnecting keeps the local listeners open while a lost ssh connection is dialed again
+	StatusReconnecting
+	// StatusFailed is a tunnel torn down after its ssh connection died, it has to be renewed to start again
+	StatusFailed
+)
+
+const (
+	DefaultKeepAliveInterval  = 30 * time.Second
+	DefaultKeepAliveMaxMissed = 3
+	DefaultReconnectAttempts  = 10
+
+	initialConnectAttempts = 3
+	reconnectBaseDelay     = 1 * time.Second
+	reconnectMaxDelay      = 60 * time.Second
+	// clientWaitTimeout bounds how long an accepted connection stalls while the tunnel reconnects
+	clientWaitTimeout = 30 * time.Second
+)
+
+var ErrConnectionLost = errors.New("ssh connection lost")
+
+const (
+	ForwardModeLocal   = "local"
+	ForwardModeDynamic = "dynamic"
+	ForwardModeRemote  = "remote"
 )
 
 type TunnelConfig struct {
-	Username   string
-	Password   string
-	LocalAddr  string
+	AuthConfig
 	ServerAddr string
-	RemoteAddr string
+	// JumpHosts are dialed in order, each through the previous one, before ServerAddr
+	JumpHosts []JumpHost
+	// Forwards share the ssh connection, each rule gets its own listener
+	Forwards []ForwardRule
+	// KeepAliveInterval and KeepAliveMaxMissed fall back to the defaults when zero
+	KeepAliveInterval  time.Duration
+	KeepAliveMaxMissed int
+	// ReconnectAttempts limits the tries after the connection is lost, zero fails the tunnel right away
+	ReconnectAttempts int
+}
+
+func (c *TunnelConfig) keepAlive() (time.Duration, int) {
+	interval, maxMissed := c.KeepAliveInterval, c.KeepAliveMaxMissed
+	if interval <= 0 {
+		interval = DefaultKeepAliveInterval
+	}
+	if maxMissed <= 0 {
+		maxMissed = DefaultKeepAliveMaxMissed
+	}
+	return interval, maxMissed
+}
+
+type ForwardRule struct {
+	// Mode is ForwardModeLocal when empty, RemoteAddr is ignored in ForwardModeDynamic.
+	// In ForwardModeRemote RemoteAddr is the address the server listens on and LocalAddr the dial target.
+	Mode          string
+	LocalAddr     string
+	RemoteAddr    string
+	SocksUsername string
+	SocksPassword string
+}
+
+type forwarder struct {
+	rule      ForwardRule
+	listener  net.Listener
+	boundAddr string
+}
+
+type JumpHost struct {
+	AuthConfig
+	Addr string
 }
 
 type Tunnel struct {
 	identifier string
 	status     TunnelStatus
 	config     *TunnelConfig
+	hostKeys   *HostKeyStore
+	pool       *ClientPool
 	quit       chan struct{}
-	sshClient  *ssh.Client
-	listener   net.Listener
+	events     *eventBus
+	boundAddrs atomic.Value
+	failure    atomic.Pointer[TunnelFailure]
 	wg         sync.WaitGroup
 	mu         sync.RWMutex
 	ctx        context.Context
 	cancel     context.CancelFunc
 	startedAt  time.Time
+
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+	accepted      atomic.Int64
+	dialFailures  atomic.Int64
+
+	statsMu    sync.Mutex
+	conns      map[uint64]*connStats
+	nextConnID uint64
+	lastErr    error
+	lastErrAt  time.Time
+
+	// open holds the accepted and dialed connections of the forwards, teardown closes them so wg.Wait returns
+	openMu sync.Mutex
+	open   map[net.Conn]struct{}
+
+	// connMu guards the ssh client and the forwarders, both are replaced while reconnecting
+	connMu      sync.Mutex
+	sshClient   *ssh.Client
+	releaseSSH  func()
+	clientReady chan struct{}
+	forwarders  []*forwarder
 }
 
 func NewTunnel(config *TunnelConfig) *Tunnel {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Tunnel{
-		config: config,
-		ctx:    ctx,
-		cancel: cancel,
-		status: StatusStopped,
+		config:      config,
+		pool:        NewClientPool(),
+		ctx:         ctx,
+		cancel:      cancel,
+		status:      StatusStopped,
+		clientReady: make(chan struct{}),
+		conns:       make(map[uint64]*connStats),
+		open:        make(map[net.Conn]struct{}),
 	}
 }
 
 func (t *Tunnel) Start(ctx context.Context) error {
+	if err := t.begin(ctx); err != nil {
+		return err
+	}
+	return t.run(ctx)
+}
+
+// begin moves a stopped tunnel to StatusStarting, from then on Stop cancels the start. The manager calls it
+// before it runs the start in the background, so a Stop right after StartTunnel is not missed.
+func (t *Tunnel) begin(ctx context.Context) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if t.status != StatusStopped {
+	if t.status != StatusStopped || t.ctx.Err() != nil {
 		logger.Error(ctx, "tunnel already running or starting", g.Map{"identifier": t.identifier, "status": t.status})
 		return fmt.Errorf("[%s] tunnel already running or starting", t.identifier)
 	}
 
-	t.status = StatusStarting
+	t.setStatus(StatusStarting)
+	return nil
+}
+
+// run connects and listens for a tunnel begin moved to StatusStarting
+func (t *Tunnel) run(ctx context.Context) error {
 	logger.Info(ctx, "tunnel starting", g.Map{"identifier": t.identifier})
-	if err := t.connectSSH(ctx); err != nil {
-		t.status = StatusStopped
-		return err
+	err := t.connectSSH(ctx)
+	if err == nil {
+		if err = t.listenNet(ctx); err != nil {
+			t.closeSSH(ctx)
+		}
 	}
 
-	if err := t.listenNet(ctx); err != nil {
-		t.status = StatusStopped
-		t.sshClient.Close()
-		return err
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Stop was called while connecting, release what was opened meanwhile
+	if t.status != StatusStarting {
+		if err == nil {
+			t.closeListeners(ctx)
+			t.closeSSH(ctx)
+			t.boundAddrs.Store([]string(nil))
+		}
+		t.setStatus(StatusStopped)
+		return fmt.Errorf("[%s] tunnel stopped while starting", t.identifier)
+	}
+
+	if err != nil {
+		return t.setFailure(err)
 	}
 
-	t.status = StatusRunning
+	t.setStatus(StatusRunning)
 	t.startedAt = time.Now()
 
-	logger.Info(ctx, "ssh tunnel established", g.Map{
-		"identifier": t.identifier,
-		"localAddr":  t.config.LocalAddr,
-		"remoteAddr": t.config.RemoteAddr,
-		"serverAddr": t.config.ServerAddr,
-	})
+	for _, f := range t.forwarders {
+		logger.Info(ctx, "ssh tunnel established", g.Map{
+			"identifier": t.identifier,
+			"mode":       f.rule.Mode,
+			"listenAddr": f.boundAddr,
+			"localAddr":  f.rule.LocalAddr,
+			"remoteAddr": f.rule.RemoteAddr,
+			"serverAddr": t.config.ServerAddr,
+		})
 
-	go t.runTunnel(ctx)
-	//go t.monitorConnection(ctx)
+		go t.runTunnel(ctx, f)
+	}
+	go t.supervise(ctx)
+	go t.reportTraffic()
 
 	return nil
 }
@@ -95,52 +242,125 @@ func (t *Tunnel) Start(ctx context.Context) error {
 func (t *Tunnel) Stop(ctx context.Context) {
 	t.mu.Lock()
 
-	if t.status != StatusRunning {
+	if t.status == StatusStarting {
+		logger.Info(ctx, "tunnel stopping while starting", g.Map{"identifier": t.identifier})
+		t.setStatus(StatusStopping)
 		t.mu.Unlock()
+		t.cancel()
+		return
+	}
+
+	if t.status != StatusRunning && t.status != StatusReconnecting {
+		t.mu.Unlock()
+		// a stopped tunnel cannot be started afterwards, a start racing with the stop is refused
+		if t.status == StatusStopped {
+			t.cancel()
+		}
 		return
 	}
 
 	logger.Info(ctx, "tunnel stopping", g.Map{"identifier": t.identifier})
-	t.status = StatusStopping
+	t.setStatus(StatusStopping)
 	t.mu.Unlock()
-	t.cancel()
 
-	if t.listener != nil {
-		if err := t.listener.Close(); err != nil {
-			logger.Error(ctx, "tunnel listener close error", g.Map{"identifier": t.identifier, "err": err.Error()})
-		}
-	}
+	t.teardown(ctx)
+	t.mu.Lock()
+	t.setStatus(StatusStopped)
+	t.mu.Unlock()
 
-	if t.sshClient != nil {
-		if err := t.sshClient.Close(); err != nil {
-			logger.Error(ctx, "ssh client close error", g.Map{"identifier": t.identifier, "err": err.Error()})
-			return
-		}
+	logger.Info(ctx, "tunnel stopped", g.Map{"identifier": t.identifier})
+}
+
+func (t *Tunnel) Status() TunnelStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.status
+}
+
+// fail tears a reconnecting tunnel down once it gives up and leaves it in StatusFailed
+func (t *Tunnel) fail(ctx context.Context, err error) {
+	t.mu.Lock()
+
+	if t.status != StatusReconnecting {
+		t.mu.Unlock()
+		return
 	}
 
-	t.wg.Wait()
+	logger.Error(ctx, "tunnel failed", g.Map{"identifier": t.identifier, "err": err.Error()})
+	t.setStatus(StatusStopping)
+	t.mu.Unlock()
+
+	t.teardown(ctx)
 	t.mu.Lock()
-	t.status = StatusStopped
+	t.setFailure(err)
 	t.mu.Unlock()
+}
 
-	logger.Info(ctx, "tunnel stopped", g.Map{"identifier": t.identifier})
+// setStatus records and announces a status change, the caller holds t.mu
+func (t *Tunnel) setStatus(status TunnelStatus) {
+	t.status = status
+	t.emit(Event{Type: EventStatus, Status: status})
 }
 
-func (t *Tunnel) runTunnel(ctx context.Context) {
+// setFailure moves the tunnel to StatusFailed, the caller holds t.mu
+func (t *Tunnel) setFailure(err error) *TunnelFailure {
+	failure := newFailure(err)
+	t.failure.Store(failure)
+	t.status = StatusFailed
+	t.emit(Event{Type: EventStatus, Status: StatusFailed, Failure: failure, Err: failure})
+	return failure
+}
+
+// Failure is the reason of a tunnel in StatusFailed, nil otherwise
+func (t *Tunnel) Failure() *TunnelFailure {
+	return t.failure.Load()
+}
+
+func (t *Tunnel) emit(event Event) {
+	event.Identifier = t.identifier
+	event.Time = time.Now()
+	if event.Err != nil {
+		t.recordError(event.Err, event.Time)
+	}
+
+	if t.events != nil {
+		t.events.emit(event)
+	}
+}
+
+func (t *Tunnel) teardown(ctx context.Context) {
+	t.cancel()
+
+	t.closeListeners(ctx)
+	t.closeSSH(ctx)
+	// a pooled connection may stay open for other tunnels, its channels would keep the forwards running
+	t.closeConns()
+
+	t.wg.Wait()
+	t.boundAddrs.Store([]string(nil))
+}
+
+func (t *Tunnel) runTunnel(ctx context.Context, f *forwarder) {
 	sem := make(chan struct{}, 20)
 	for {
 		select {
 		case <-t.ctx.Done():
 			return
 		default:
-			conn, err := t.listener.Accept()
+			conn, err := f.listener.Accept()
 			if err != nil {
 				if !strings.Contains(err.Error(), "use of closed network connection") {
 					logger.Error(ctx, "tunnel accept error", g.Map{"identifier": t.identifier, "err": err.Error()})
+					t.emit(Event{Type: EventError, Err: err})
+				}
+				// a remote listener reports EOF once the ssh connection is gone
+				if errors.Is(err, io.EOF) {
+					return
 				}
 				continue
 			}
 
+			t.accepted.Add(1)
 			select {
 			case sem <- struct{}{}:
 				t.wg.Add(1)
@@ -149,7 +369,11 @@ func (t *Tunnel) runTunnel(ctx context.Context) {
 						<-sem
 						t.wg.Done()
 					}()
-					t.forward(t.ctx, conn)
+					if !t.hold(c) {
+						return
+					}
+					defer t.release(c)
+					t.forward(t.ctx, f.rule, c)
 				}(conn)
 			case <-t.ctx.Done():
 				conn.Close()
@@ -159,96 +383,588 @@ func (t *Tunnel) runTunnel(ctx context.Context) {
 	}
 }
 
-func (t *Tunnel) forward(ctx context.Context, localConn net.Conn) {
-	remoteConn, err := t.sshClient.Dial("tcp", t.config.RemoteAddr)
+func (t *Tunnel) forward(ctx context.Context, rule ForwardRule, localConn net.Conn) {
+	defer localConn.Close()
+
+	client := localConn.RemoteAddr().String()
+	if rule.Mode == ForwardModeRemote {
+		targetConn, err := net.DialTimeout("tcp", rule.LocalAddr, 10*time.Second)
+		if err != nil {
+			logger.Error(ctx, "local addr dial error", g.Map{"identifier": t.identifier, "target": rule.LocalAddr, "err": err.Error()})
+			t.dialFailures.Add(1)
+			t.emit(Event{Type: EventError, Client: client, Target: rule.LocalAddr, Err: err})
+			return
+		}
+		if !t.hold(targetConn) {
+			return
+		}
+		defer t.release(targetConn)
+
+		t.pipe(ctx, localConn, targetConn, client, rule.LocalAddr)
+		return
+	}
+
+	target := rule.RemoteAddr
+	if rule.Mode == ForwardModeDynamic {
+		addr, err := socksHandshake(localConn, rule.SocksUsername, rule.SocksPassword)
+		if err != nil {
+			logger.Error(ctx, "socks handshake error", g.Map{"identifier": t.identifier, "client": client, "err": err.Error()})
+			t.emit(Event{Type: EventError, Client: client, Err: err})
+			return
+		}
+		target = addr
+	}
+
+	remoteConn, err := t.dial(target)
 	if err != nil {
-		logger.Error(ctx, "remote addr dial error", g.Map{"identifier": t.identifier, "err": err.Error()})
+		logger.Error(ctx, "remote addr dial error", g.Map{"identifier": t.identifier, "target": target, "err": err.Error()})
+		t.dialFailures.Add(1)
+		t.emit(Event{Type: EventError, Client: client, Target: target, Err: err})
+		if rule.Mode == ForwardModeDynamic {
+			socksReply(localConn, socksDialReply(err))
+		}
 		return
 	}
-	defer remoteConn.Close()
+	if !t.hold(remoteConn) {
+		if rule.Mode == ForwardModeDynamic {
+			socksReply(localConn, socksReplyGeneralFailure)
+		}
+		return
+	}
+	defer t.release(remoteConn)
+
+	if rule.Mode == ForwardModeDynamic {
+		if err := socksReply(localConn, socksReplySucceeded); err != nil {
+			logger.Error(ctx, "socks reply error", g.Map{"identifier": t.identifier, "err": err.Error()})
+			return
+		}
+	}
+
+	t.pipe(ctx, localConn, remoteConn, client, target)
+}
+
+// pipe copies both directions between the accepted connection and the target until both sides are done
+func (t *Tunnel) pipe(ctx context.Context, localConn net.Conn, remoteConn net.Conn, client string, target string) {
+	conn := t.trackConn(client, target)
+	t.emit(Event{Type: EventConnOpened, Client: client, Target: target})
+	defer func() {
+		t.untrackConn(conn)
+		t.emit(Event{Type: EventConnClosed, Client: client, Target: target, BytesSent: conn.sent.Load(), BytesReceived: conn.received.Load()})
+	}()
 
-	buf := make([]byte, 32*1024)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		n, err := io.CopyBuffer(remoteConn, localConn, buf)
+		n, err := io.Copy(&countingWriter{w: remoteConn, counters: []*atomic.Int64{&conn.sent, &t.bytesSent}}, localConn)
+		closeWrite(remoteConn)
 		if err != nil {
 			logger.Error(ctx, "local to remote server forwarding err", g.Map{"identifier": t.identifier, "err": err.Error()})
 			return
 		}
-		logger.Info(ctx, "local to remote server forwarding completed", g.Map{"identifier": t.identifier, "transmit": n})
+		logger.Info(ctx, "local to remote server forwarding completed", g.Map{"identifier": t.identifier, "target": target, "transmit": n})
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		n, err := io.CopyBuffer(localConn, remoteConn, buf)
+		n, err := io.Copy(&countingWriter{w: localConn, counters: []*atomic.Int64{&conn.received, &t.bytesReceived}}, remoteConn)
+		closeWrite(localConn)
 		if err != nil {
 			logger.Error(ctx, "remote server to local forwarding err", g.Map{"identifier": t.identifier, "err": err.Error()})
 			return
 		}
-		logger.Info(ctx, "local to remote server forwarding completed", g.Map{"identifier": t.identifier, "transmit": n})
+		logger.Info(ctx, "remote server to local forwarding completed", g.Map{"identifier": t.identifier, "target": target, "transmit": n})
 	}()
 
 	wg.Wait()
 }
 
+// hold registers a connection of a forward for teardown, it is closed right away when the tunnel is stopping
+func (t *Tunnel) hold(conn net.Conn) bool {
+	t.openMu.Lock()
+	defer t.openMu.Unlock()
+
+	if t.ctx.Err() != nil {
+		conn.Close()
+		return false
+	}
+	t.open[conn] = struct{}{}
+	return true
+}
+
+// release closes a held connection once its forward is done
+func (t *Tunnel) release(conn net.Conn) {
+	t.openMu.Lock()
+	delete(t.open, conn)
+	t.openMu.Unlock()
+
+	conn.Close()
+}
+
+// closeConns closes the held connections, the ctx is canceled before so none are held afterwards
+func (t *Tunnel) closeConns() {
+	t.openMu.Lock()
+	defer t.openMu.Unlock()
+
+	for conn := range t.open {
+		conn.Close()
+	}
+}
+
+// reportTraffic announces the byte totals of a running tunnel while they change
+func (t *Tunnel) reportTraffic() {
+	ticker := time.NewTicker(trafficInterval)
+	defer ticker.Stop()
+
+	var lastSent, lastReceived int64
+	for {
+		select {
+		case <-ticker.C:
+			sent, received := t.bytesSent.Load(), t.bytesReceived.Load()
+			if sent == lastSent && received == lastReceived {
+				continue
+			}
+			lastSent, lastReceived = sent, received
+			t.emit(Event{Type: EventTraffic, BytesSent: sent, BytesReceived: received})
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// closeWrite half-closes conn so the peer sees EOF while the other direction keeps flowing
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// listenNet opens one listener per forward rule, nothing stays open when one of them fails
 func (t *Tunnel) listenNet(ctx context.Context) error {
-	listener, err := net.Listen("tcp", t.config.LocalAddr)
+	forwarders := make([]*forwarder, 0, len(t.config.Forwards))
+	addrs := make([]string, 0, len(t.config.Forwards))
+	for _, rule := range t.config.Forwards {
+		// the forwarder keeps its own copy of the rule, the socks password is resolved into it
+		socksPassword, err := ResolveSecret(ctx, rule.SocksPassword)
+		if err != nil {
+			for _, f := range forwarders {
+				f.listener.Close()
+			}
+			return fmt.Errorf("%w: %w", ErrSecretUnavailable, err)
+		}
+		rule.SocksPassword = socksPassword
+
+		listener, err := t.listen(ctx, rule)
+		if err != nil {
+			for _, f := range forwarders {
+				f.listener.Close()
+			}
+			return err
+		}
+
+		f := &forwarder{rule: rule, listener: listener, boundAddr: listener.Addr().String()}
+		forwarders = append(forwarders, f)
+		addrs = append(addrs, f.boundAddr)
+	}
+
+	t.forwarders = forwarders
+	t.boundAddrs.Store(addrs)
+	return nil
+}
+
+// listen expects the ssh client to be connected for remote rules
+func (t *Tunnel) listen(ctx context.Context, rule ForwardRule) (net.Listener, error) {
+	if rule.Mode == ForwardModeRemote {
+		listener, err := t.sshClient.Listen("tcp", rule.RemoteAddr)
+		if err != nil {
+			err = listenError(err)
+			logger.Error(ctx, "remote listen error", g.Map{
+				"identifier": t.identifier,
+				"remoteAddr": rule.RemoteAddr,
+				"err":        err.Error(),
+			})
+			return nil, err
+		}
+
+		return listener, nil
+	}
+
+	listener, err := net.Listen("tcp", rule.LocalAddr)
 	if err != nil {
+		err = listenError(err)
 		logger.Error(ctx, "listen error", g.Map{
 			"identifier": t.identifier,
-			"localAddr":  t.config.LocalAddr,
+			"localAddr":  rule.LocalAddr,
 			"err":        err.Error(),
 		})
-		return err
+		return nil, err
 	}
 
-	t.listener = listener
-	return nil
+	return listener, nil
+}
+
+func (t *Tunnel) closeListeners(ctx context.Context) {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+
+	for _, f := range t.forwarders {
+		if err := f.listener.Close(); err != nil {
+			logger.Error(ctx, "tunnel listener close error", g.Map{"identifier": t.identifier, "listenAddr": f.boundAddr, "err": err.Error()})
+		}
+	}
+}
+
+// ListenAddrs are the addresses actually bound by the running tunnel in the order of the forward rules,
+// remote rules report the address bound on the ssh server
+func (t *Tunnel) ListenAddrs() []string {
+	addrs, _ := t.boundAddrs.Load().([]string)
+	return addrs
 }
 
 func (t *Tunnel) connectSSH(ctx context.Context) error {
-	config := &ssh.ClientConfig{
-		User:            t.config.Username,
-		Auth:            []ssh.AuthMethod{ssh.Password(t.config.Password)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
+	return t.retry(ctx, initialConnectAttempts, t.acquireSSH)
+}
+
+// acquireSSH borrows a connection for the whole hop chain from the pool
+func (t *Tunnel) acquireSSH(ctx context.Context) error {
+	hops := make([]JumpHost, 0, len(t.config.JumpHosts)+1)
+	hops = append(hops, t.config.JumpHosts...)
+	hops = append(hops, JumpHost{AuthConfig: t.config.AuthConfig, Addr: t.config.ServerAddr})
+
+	// credentials are only resolved for the dial and the pool key, the config keeps the references
+	for i := range hops {
+		auth, err := hops[i].AuthConfig.resolve(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", hops[i].Addr, err)
+		}
+		hops[i].AuthConfig = auth
+	}
+
+	client, release, err := t.pool.Acquire(ctx, poolKey(hops), func() ([]*ssh.Client, error) {
+		return t.dialChain(ctx, hops)
+	})
+	if err != nil {
+		return err
 	}
 
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+
+	// the tunnel was stopped while dialing
+	if err := t.ctx.Err(); err != nil {
+		release()
+		return err
+	}
+
+	t.sshClient = client
+	t.releaseSSH = release
+	close(t.clientReady)
+	return nil
+}
+
+// retry runs connect up to attempts times with a jittered exponential backoff in between,
+// host key, authentication and credential errors are not retried as the next attempt would be refused the same way,
+// repeated failed logins may also get the client banned. A stopped tunnel gives up right away.
+func (t *Tunnel) retry(ctx context.Context, attempts int, connect func(ctx context.Context) error) error {
 	var err error
-	var sshClient *ssh.Client
+	for i := 1; i <= attempts; i++ {
+		if i > 1 {
+			select {
+			case <-time.After(backoff(i - 1)):
+			case <-t.ctx.Done():
+				return fmt.Errorf("[%s] ssh connect canceled: %w", t.identifier, t.ctx.Err())
+			}
+		}
 
-	for i := 1; i <= 3; i++ {
-		sshClient, err = ssh.Dial("tcp", t.config.ServerAddr, config)
-		if err == nil {
-			t.sshClient = sshClient
+		if err = connect(ctx); err == nil {
 			return nil
 		}
 
 		logger.Error(ctx, "ssh connect error", g.Map{"identifier": t.identifier, "err": err.Error(), "retry": i})
-		time.Sleep(1 * time.Second)
+		t.emit(Event{Type: EventError, Err: err})
+		if errors.Is(err, ErrHostKeyMismatch) || errors.Is(err, ErrHostKeyRejected) || errors.Is(err, ErrSecretUnavailable) ||
+			errors.Is(err, ErrAuthFailed) {
+			return fmt.Errorf("[%s] ssh connect error: %w", t.identifier, err)
+		}
+	}
+
+	return fmt.Errorf("[%s] ssh connect after %d attempts: %w", t.identifier, attempts, err)
+}
+
+// backoff doubles the delay for every failed attempt up to reconnectMaxDelay,
+// the jitter keeps tunnels sharing a server from retrying in lockstep
+func backoff(failures int) time.Duration {
+	delay := reconnectMaxDelay
+	if failures < 8 {
+		delay = min(reconnectBaseDelay<<(failures-1), reconnectMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// dial opens a channel through the current connection, it waits for a running reconnect to finish
+func (t *Tunnel) dial(target string) (net.Conn, error) {
+	timeout := time.NewTimer(clientWaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		t.connMu.Lock()
+		client, ready := t.sshClient, t.clientReady
+		t.connMu.Unlock()
+
+		if client != nil {
+			return client.Dial("tcp", target)
+		}
+
+		select {
+		case <-ready:
+		case <-timeout.C:
+			return nil, fmt.Errorf("ssh reconnect not finished after %s", clientWaitTimeout)
+		case <-t.ctx.Done():
+			return nil, t.ctx.Err()
+		}
+	}
+}
+
+// supervise watches the connection of a running tunnel and reconnects when it dies
+func (t *Tunnel) supervise(ctx context.Context) {
+	for {
+		t.connMu.Lock()
+		client := t.sshClient
+		t.connMu.Unlock()
+
+		// the tunnel was stopped meanwhile
+		if client == nil {
+			return
+		}
+
+		cause := t.monitorConnection(ctx, client)
+		if cause == nil || !t.reconnect(ctx, client, cause) {
+			return
+		}
+	}
+}
+
+// reconnect replaces the dead connection monitorConnection watched while the local listeners stay open,
+// so clients only stall meanwhile. The tunnel fails once the attempts are exhausted.
+func (t *Tunnel) reconnect(ctx context.Context, dead *ssh.Client, cause error) bool {
+	t.mu.Lock()
+	if t.status != StatusRunning {
+		t.mu.Unlock()
+		return false
+	}
+	t.setStatus(StatusReconnecting)
+	t.mu.Unlock()
+
+	logger.Error(ctx, "ssh connection lost, reconnecting", g.Map{"identifier": t.identifier, "err": cause.Error()})
+
+	// the connection is dead for every tunnel sharing it, the others reconnect too and the pool dials a fresh one
+	t.pool.Discard(dead)
+	t.closeSSH(ctx)
+
+	attempts := t.config.ReconnectAttempts
+	if attempts <= 0 {
+		t.fail(ctx, cause)
+		return false
+	}
+
+	err := t.retry(ctx, attempts, func(ctx context.Context) error {
+		if err := t.acquireSSH(ctx); err != nil {
+			return err
+		}
+		if err := t.relisten(ctx); err != nil {
+			t.closeSSH(ctx)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if t.ctx.Err() == nil {
+			t.fail(ctx, fmt.Errorf("%w, %w", cause, err))
+		}
+		return false
+	}
+
+	t.mu.Lock()
+	if t.status != StatusReconnecting {
+		t.mu.Unlock()
+		return false
+	}
+	t.setStatus(StatusRunning)
+	t.mu.Unlock()
+
+	logger.Info(ctx, "ssh connection restored", g.Map{"identifier": t.identifier, "serverAddr": t.config.ServerAddr})
+	return true
+}
+
+// relisten binds the remote rules again on the new connection, the server dropped them with the old one
+func (t *Tunnel) relisten(ctx context.Context) error {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+
+	forwarders := slices.Clone(t.forwarders)
+	addrs := slices.Clone(t.ListenAddrs())
+	opened := make([]*forwarder, 0, len(forwarders))
+	for i, f := range forwarders {
+		if f.rule.Mode != ForwardModeRemote {
+			continue
+		}
+
+		listener, err := t.listen(ctx, f.rule)
+		if err != nil {
+			for _, f := range opened {
+				f.listener.Close()
+			}
+			return err
+		}
+
+		forwarders[i] = &forwarder{rule: f.rule, listener: listener, boundAddr: listener.Addr().String()}
+		addrs[i] = forwarders[i].boundAddr
+		opened = append(opened, forwarders[i])
+	}
+
+	t.forwarders = forwarders
+	t.boundAddrs.Store(addrs)
+	for _, f := range opened {
+		go t.runTunnel(ctx, f)
+	}
+
+	return nil
+}
+
+func (t *Tunnel) dialChain(ctx context.Context, hops []JumpHost) ([]*ssh.Client, error) {
+	clients := make([]*ssh.Client, 0, len(hops))
+	for _, hop := range hops {
+		var via *ssh.Client
+		if len(clients) > 0 {
+			via = clients[len(clients)-1]
+		}
+
+		client, err := t.dialHop(ctx, via, hop)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("%s: %w", hop.Addr, err)
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+func (t *Tunnel) dialHop(ctx context.Context, via *ssh.Client, hop JumpHost) (*ssh.Client, error) {
+	auth, release, err := hop.authMethods(ctx)
+	if err != nil {
+		logger.Error(ctx, "ssh auth config error", g.Map{"identifier": t.identifier, "addr": hop.Addr, "err": err.Error()})
+		return nil, fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	}
+	defer release()
+
+	config := &ssh.ClientConfig{
+		User:              hop.Username,
+		Auth:              auth,
+		HostKeyCallback:   t.hostKeys.Callback(ctx),
+		HostKeyAlgorithms: t.hostKeys.Algorithms(ctx, hop.Addr),
+		Timeout:           30 * time.Second,
+	}
+
+	if via == nil {
+		client, err := ssh.Dial("tcp", hop.Addr, config)
+		return client, authError(err)
+	}
+
+	conn, err := via.Dial("tcp", hop.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, hop.Addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, authError(err)
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// closeSSH hands the connection back to the pool, it is closed once no other tunnel uses it
+func (t *Tunnel) closeSSH(ctx context.Context) {
+	t.connMu.Lock()
+	release := t.releaseSSH
+	t.sshClient = nil
+	t.releaseSSH = nil
+	if release != nil {
+		t.clientReady = make(chan struct{})
+	}
+	t.connMu.Unlock()
+
+	if release != nil {
+		release()
 	}
+}
 
-	return fmt.Errorf("[%s] ssh connect after 3 attempts", t.identifier)
+// closeClients closes the target connection first, then the jump hosts from the nearest to the farthest
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
 }
 
-func (t *Tunnel) monitorConnection(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+// monitorConnection sends keepalive requests until the tunnel stops, it returns the cause once the server
+// misses too many in a row or the connection is closed underneath it
+func (t *Tunnel) monitorConnection(ctx context.Context, client *ssh.Client) error {
+	interval, maxMissed := t.config.keepAlive()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	discarded := t.pool.Dead(client)
+
+	missed := 0
 	for {
 		select {
 		case <-ticker.C:
-			_, _, err := t.sshClient.SendRequest(fmt.Sprintf("http://%s", t.config.LocalAddr), true, nil)
-			if err != nil {
-				logger.Error(ctx, "keepalive err", g.Map{"identifier": t.identifier, "err": err.Error()})
-				t.Stop(ctx)
-				return
+			if err := sendKeepAlive(client, interval); err != nil {
+				missed++
+				logger.Error(ctx, "ssh keepalive missed", g.Map{"identifier": t.identifier, "missed": missed, "err": err.Error()})
+				t.emit(Event{Type: EventError, Err: err})
+				if missed >= maxMissed {
+					return fmt.Errorf("[%s] %w: %d keepalives missed", t.identifier, ErrConnectionLost, missed)
+				}
+				continue
 			}
+			missed = 0
+		case <-closed:
+			return fmt.Errorf("[%s] %w: connection closed", t.identifier, ErrConnectionLost)
+		case <-discarded:
+			return fmt.Errorf("[%s] %w: connection discarded by a tunnel sharing it", t.identifier, ErrConnectionLost)
 		case <-t.ctx.Done():
-			return
+			return nil
 		}
 	}
 }
+
+// sendKeepAlive waits at most timeout for the reply, a rejected request still proves the server is alive
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("keepalive timeout after %s", timeout)
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a field of a config which does not pass Validate, Field is its json key. Forward is the index
+// of the rule in AllForwards, 0 being the main rule, JumpHost the index of the hop, both are -1 when the field
+// is not theirs.
+type FieldError struct {
+	Field    string
+	Forward  int
+	JumpHost int
+	Message  string
+}
+
+func (e *FieldError) Error() string {
+	switch {
+	case e.JumpHost >= 0:
+		return fmt.Sprintf("jump host %d: %s", e.JumpHost+1, e.Message)
+	case e.Forward > 0:
+		return fmt.Sprintf("forward %d: %s", e.Forward, e.Message)
+	}
+	return e.Message
+}
+
+// ValidationError lists every field of a config which does not pass Validate
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// UsesPassword and UsesPrivateKey tell the credentials an auth type needs, key_password needs both
+func UsesPassword(authType string) bool {
+	return authType == AuthTypePassword || authType == AuthTypeKeyPassword
+}
+
+func UsesPrivateKey(authType string) bool {
+	return authType == AuthTypeKey || authType == AuthTypeKeyPassword
+}
+
+var (
+	authTypes    = []string{AuthTypePassword, AuthTypeKey, AuthTypeKeyPassword, AuthTypeAgent}
+	forwardModes = []string{ForwardModeLocal, ForwardModeRemote, ForwardModeDynamic}
+)
+
+// Validate checks a config before it is stored, the editor and the cli both run it. The error is a
+// *ValidationError with every invalid field, so a form can mark all of them at once.
+func (c *ConfigFile) Validate() error {
+	v := &ValidationError{}
+	add := func(field string, message string) {
+		v.Fields = append(v.Fields, &FieldError{Field: field, Forward: -1, JumpHost: -1, Message: message})
+	}
+
+	if c.ConfigName == "" {
+		add("config_name", "config name is empty")
+	}
+	if c.ServerIP == "" {
+		add("server_ip", "server ip is empty")
+	}
+	if c.ServerPort == "" {
+		add("server_port", "server port is empty")
+	}
+	if c.UserName == "" {
+		add("user_name", "username is empty")
+	}
+	if !slices.Contains(authTypes, c.AuthType) {
+		add("auth_type", fmt.Sprintf("auth type %q is invalid", c.AuthType))
+	}
+	if UsesPassword(c.AuthType) && c.Password == "" {
+		add("password", "password is empty")
+	}
+	if UsesPrivateKey(c.AuthType) && c.PrivateKeyPath == "" && c.PrivateKey == "" {
+		add("private_key_path", "private key is empty")
+	}
+	if !isPositiveInt(c.KeepAliveInterval) {
+		add("keepalive_interval", "keepalive interval is invalid")
+	}
+	if !isPositiveInt(c.KeepAliveMaxMissed) {
+		add("keepalive_max_missed", "keepalive max missed is invalid")
+	}
+	if attempts, err := strconv.Atoi(c.ReconnectAttempts); c.ReconnectAttempts != "" && (err != nil || attempts < 0) {
+		add("reconnect_attempts", "reconnect attempts is invalid")
+	}
+
+	for i, hop := range c.JumpHosts {
+		for _, field := range hop.validate() {
+			field.JumpHost = i
+			v.Fields = append(v.Fields, field)
+		}
+	}
+	for i, forward := range c.AllForwards() {
+		for _, field := range forward.validate() {
+			field.Forward = i
+			v.Fields = append(v.Fields, field)
+		}
+	}
+
+	if len(v.Fields) > 0 {
+		return v
+	}
+	return nil
+}
+
+func (h *JumpHostConfig) validate() []*FieldError {
+	fields := make([]*FieldError, 0)
+	add := func(field string, message string) {
+		fields = append(fields, &FieldError{Field: field, Forward: -1, Message: message})
+	}
+
+	if h.IP == "" {
+		add("ip", "ip is empty")
+	}
+	if h.Port == "" {
+		add("port", "port is empty")
+	}
+	if h.UserName == "" {
+		add("user_name", "username is empty")
+	}
+	if !slices.Contains(authTypes, h.AuthType) {
+		add("auth_type", fmt.Sprintf("auth type %q is invalid", h.AuthType))
+	}
+	if UsesPassword(h.AuthType) && h.Password == "" {
+		add("password", "password is empty")
+	}
+	if UsesPrivateKey(h.AuthType) && h.PrivateKeyPath == "" && h.PrivateKey == "" {
+		add("private_key_path", "private key is empty")
+	}
+	return fields
+}
+
+func (f *ForwardConfig) validate() []*FieldError {
+	fields := make([]*FieldError, 0)
+	add := func(field string, message string) {
+		fields = append(fields, &FieldError{Field: field, JumpHost: -1, Message: message})
+	}
+
+	if !slices.Contains(forwardModes, f.Mode) {
+		add("mode", fmt.Sprintf("forward mode %q is invalid", f.Mode))
+	}
+	if f.Mode == ForwardModeLocal && f.RemoteIP == "" {
+		add("remote_ip", "remote ip is empty")
+	}
+	if f.Mode != ForwardModeDynamic && f.RemotePort == "" {
+		add("remote_port", "remote port is empty")
+	}
+	if ip := f.LocalIP; ip != "" && net.ParseIP(ip) == nil && ip != "localhost" {
+		add("local_ip", "local ip is invalid")
+	}
+
+	port, err := strconv.Atoi(f.LocalPort)
+	switch {
+	case f.LocalPort == "" && f.Mode == ForwardModeDynamic:
+		add("local_port", "local port is empty")
+	case f.LocalPort == "":
+	case err != nil || port < 0 || port > 65535:
+		add("local_port", "local port is invalid")
+	case port == 0 && f.Mode == ForwardModeRemote:
+		add("local_port", "local target port can not be 0")
+	}
+	return fields
+}
+
+// isPositiveInt accepts empty text for optional fields that fall back to a default
+func isPositiveInt(text string) bool {
+	if text == "" {
+		return true
+	}
+	n, err := strconv.Atoi(text)
+	return err == nil && n > 0
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateReportsEveryField(t *testing.T) {
+	config := testConfig("db")
+	config.ServerIP = ""
+	config.KeepAliveInterval = "0"
+	config.JumpHosts = []*JumpHostConfig{{IP: "jump", Port: "22", UserName: "j", AuthType: AuthTypeKey}}
+	config.Forwards = []*ForwardConfig{{Mode: ForwardModeRemote, LocalPort: "0", RemotePort: "80"}}
+
+	var validationErr *ValidationError
+	if err := config.Validate(); !errors.As(err, &validationErr) {
+		t.Fatalf("err = %v, want a ValidationError", err)
+	}
+
+	want := []FieldError{
+		{Field: "server_ip", Forward: -1, JumpHost: -1},
+		{Field: "keepalive_interval", Forward: -1, JumpHost: -1},
+		{Field: "private_key_path", Forward: -1, JumpHost: 0},
+		{Field: "local_port", Forward: 1, JumpHost: -1},
+	}
+	if len(validationErr.Fields) != len(want) {
+		t.Fatalf("fields = %v, want %d", validationErr, len(want))
+	}
+	for i, field := range validationErr.Fields {
+		if field.Field != want[i].Field || field.Forward != want[i].Forward || field.JumpHost != want[i].JumpHost {
+			t.Errorf("field %d = %+v, want %+v", i, *field, want[i])
+		}
+	}
+}
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	if err := testConfig("db").Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
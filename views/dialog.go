@@ -0,0 +1,207 @@
+package views
+
+import (
+	"context"
+	"gioui.org/font"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image"
+	"image/color"
+)
+
+type Dialog struct {
+	title       string
+	message     string
+	confirmText string
+	cancelText  string
+	confirmBtn  widget.Clickable
+	cancelBtn   widget.Clickable
+	maskBtn     widget.Clickable
+	answer      chan bool
+	answered    bool
+	// input is only set for prompts, text is what it held when the user confirmed
+	input   *widget.Editor
+	text    string
+	focused bool
+}
+
+// Confirm shows a modal dialog and blocks until the user answers or ctx is done,
+// it must not be called from the frame loop.
+func (w *Window) Confirm(ctx context.Context, title, message, confirmText, cancelText string) bool {
+	return w.show(ctx, &Dialog{
+		title:       title,
+		message:     message,
+		confirmText: confirmText,
+		cancelText:  cancelText,
+		answer:      make(chan bool, 1),
+	})
+}
+
+// Alert shows a message with a single button and blocks until the user dismisses it
+func (w *Window) Alert(ctx context.Context, title, message, confirmText string) {
+	w.show(ctx, &Dialog{
+		title:       title,
+		message:     message,
+		confirmText: confirmText,
+		answer:      make(chan bool, 1),
+	})
+}
+
+// Prompt is Confirm with a masked input, the text is returned when the user confirms
+func (w *Window) Prompt(ctx context.Context, title, message, confirmText, cancelText string) (string, bool) {
+	dialog := &Dialog{
+		title:       title,
+		message:     message,
+		confirmText: confirmText,
+		cancelText:  cancelText,
+		answer:      make(chan bool, 1),
+		input:       &widget.Editor{SingleLine: true, Submit: true, Mask: '•'},
+	}
+
+	if !w.show(ctx, dialog) {
+		return "", false
+	}
+	return dialog.text, true
+}
+
+func (w *Window) show(ctx context.Context, dialog *Dialog) bool {
+	w.dialogMu.Lock()
+	defer w.dialogMu.Unlock()
+
+	w.mutex.Lock()
+	w.dialog = dialog
+	w.mutex.Unlock()
+	w.window.Invalidate()
+
+	defer func() {
+		w.mutex.Lock()
+		w.dialog = nil
+		w.mutex.Unlock()
+		w.window.Invalidate()
+	}()
+
+	select {
+	case ok := <-dialog.answer:
+		return ok
+	case <-ctx.Done():
+		return false
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+func (w *Window) layoutDialog(gtx layout.Context) layout.Dimensions {
+	w.mutex.Lock()
+	dialog := w.dialog
+	w.mutex.Unlock()
+
+	if dialog == nil {
+		return layout.Dimensions{}
+	}
+
+	return dialog.Layout(gtx, w.th)
+}
+
+func (d *Dialog) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
+	confirmed := d.confirmBtn.Clicked(gtx)
+	if d.cancelBtn.Clicked(gtx) {
+		d.reply(false)
+	}
+	if d.input != nil {
+		if !d.focused {
+			gtx.Execute(key.FocusCmd{Tag: d.input})
+			d.focused = true
+		}
+		for {
+			event, ok := d.input.Update(gtx)
+			if !ok {
+				break
+			}
+			if _, ok := event.(widget.SubmitEvent); ok {
+				confirmed = true
+			}
+		}
+	}
+	if confirmed {
+		d.reply(true)
+	}
+
+	// the mask swallows clicks so the form below is not reachable while the dialog is open
+	return d.maskBtn.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		paint.FillShape(gtx.Ops, color.NRGBA{A: 120}, clip.Rect{Max: gtx.Constraints.Max}.Op())
+		return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(unit.Dp(460))
+			gtx.Constraints.Max.X = gtx.Constraints.Min.X
+			return layout.Background{}.Layout(gtx,
+				func(gtx layout.Context) layout.Dimensions {
+					rr := gtx.Dp(unit.Dp(6))
+					paint.FillShape(gtx.Ops, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, clip.UniformRRect(image.Rectangle{Max: gtx.Constraints.Min}, rr).Op(gtx.Ops))
+					return layout.Dimensions{Size: gtx.Constraints.Min}
+				},
+				func(gtx layout.Context) layout.Dimensions {
+					return layout.UniformInset(unit.Dp(20)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								t := material.Body1(th, d.title)
+								t.Font.Weight = font.Bold
+								return t.Layout(gtx)
+							}),
+							layout.Rigid(layout.Spacer{Height: 10}.Layout),
+							layout.Rigid(material.Body2(th, d.message).Layout),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								if d.input == nil {
+									return layout.Dimensions{}
+								}
+								return layout.Inset{Top: 10}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+									border := widget.Border{Color: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF}, Width: unit.Dp(1), CornerRadius: unit.Dp(4)}
+									return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+										return layout.UniformInset(5).Layout(gtx, material.Editor(th, d.input, "").Layout)
+									})
+								})
+							}),
+							layout.Rigid(layout.Spacer{Height: 20}.Layout),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceStart}.Layout(gtx,
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										if d.cancelText == "" {
+											return layout.Dimensions{}
+										}
+										btn := material.Button(th, &d.cancelBtn, d.cancelText)
+										btn.Inset = layout.Inset{Top: 6, Bottom: 6, Left: 10, Right: 10}
+										btn.Background = color.NRGBA{R: 160, G: 160, B: 160, A: 255}
+										return btn.Layout(gtx)
+									}),
+									layout.Rigid(layout.Spacer{Width: 10}.Layout),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										btn := material.Button(th, &d.confirmBtn, d.confirmText)
+										btn.Inset = layout.Inset{Top: 6, Bottom: 6, Left: 10, Right: 10}
+										btn.Background = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+										return btn.Layout(gtx)
+									}),
+								)
+							}),
+						)
+					})
+				},
+			)
+		})
+	})
+}
+
+// reply is called from the frame loop only, the first answer counts
+func (d *Dialog) reply(ok bool) {
+	if d.answered {
+		return
+	}
+	d.answered = true
+
+	if ok && d.input != nil {
+		d.text = d.input.Text()
+	}
+	d.answer <- ok
+}
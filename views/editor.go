@@ -2,7 +2,7 @@ package views
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"gioui.org/layout"
 	"gioui.org/text"
 	"gioui.org/unit"
@@ -11,13 +11,17 @@ import (
 	"image"
 	"image/color"
 	"log"
-	"time"
 	"xtunnel/service"
 )
 
 const ModeCreate = 1
 const ModeEdit = 2
 
+const (
+	tabConfig = iota
+	tabStatus
+)
+
 type Editor struct {
 	window          *Window
 	mode            int
@@ -34,6 +38,31 @@ type Editor struct {
 	passwordInput   widget.Editor
 	saveButton      widget.Clickable
 	deleteButton    widget.Clickable
+	listState       widget.List
+	tab             int
+	configTab       widget.Clickable
+	statusTab       widget.Clickable
+	monitor         *Monitor
+	authTypeEnum    widget.Enum
+	modeEnum        widget.Enum
+
+	privateKeyPathInput widget.Editor
+	privateKeyInput     widget.Editor
+	passphraseInput     widget.Editor
+	agentKeyInput       widget.Editor
+	socksUsernameInput  widget.Editor
+	socksPasswordInput  widget.Editor
+	localIpInput        widget.Editor
+	localPortInput      widget.Editor
+	keepAliveInput      widget.Editor
+	maxMissedInput      widget.Editor
+	reconnectInput      widget.Editor
+	autoStartCheck      widget.Bool
+
+	jumpHosts         []*jumpHostRow
+	addJumpHostButton widget.Clickable
+	forwards          []*forwardRow
+	addForwardButton  widget.Clickable
 
 	configNameInputWidget *InputWidget
 	remoteIpInputWidget   *InputWidget
@@ -42,6 +71,18 @@ type Editor struct {
 	serverPortInputWidget *InputWidget
 	usernameInputWidget   *InputWidget
 	passwordInputWidget   *InputWidget
+
+	privateKeyPathInputWidget *InputWidget
+	privateKeyInputWidget     *InputWidget
+	passphraseInputWidget     *InputWidget
+	agentKeyInputWidget       *InputWidget
+	socksUsernameInputWidget  *InputWidget
+	socksPasswordInputWidget  *InputWidget
+	localIpInputWidget        *InputWidget
+	localPortInputWidget      *InputWidget
+	keepAliveInputWidget      *InputWidget
+	maxMissedInputWidget      *InputWidget
+	reconnectInputWidget      *InputWidget
 }
 
 type InputWidget struct {
@@ -62,6 +103,9 @@ func NewEditor(w *Window) *Editor {
 		passwordInput:   widget.Editor{},
 		saveButton:      widget.Clickable{},
 		deleteButton:    widget.Clickable{},
+		listState:       widget.List{List: layout.List{Axis: layout.Vertical}},
+		authTypeEnum:    widget.Enum{Value: service.AuthTypePassword},
+		modeEnum:        widget.Enum{Value: service.ForwardModeLocal},
 
 		configNameInputWidget: &InputWidget{
 			Input:  &Input{},
@@ -91,7 +135,52 @@ func NewEditor(w *Window) *Editor {
 			Input:  &Input{},
 			Editor: widget.Editor{},
 		},
+		privateKeyPathInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		privateKeyInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		passphraseInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		agentKeyInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		socksUsernameInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		socksPasswordInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		localIpInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		localPortInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		keepAliveInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		maxMissedInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
+		reconnectInputWidget: &InputWidget{
+			Input:  &Input{},
+			Editor: widget.Editor{},
+		},
 	}
+	editor.monitor = NewMonitor(w)
 	if w.ui.sidebar.SelectedItem != nil {
 		editor.SwitchEditMode()
 	}
@@ -106,257 +195,420 @@ func (e *Editor) Layout() layout.Dimensions {
 	gtx.Constraints = layout.Exact(image.Pt(560, gtx.Constraints.Max.Y))
 	return layout.Inset{Left: unit.Dp(10), Right: unit.Dp(20)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				t := material.Body1(th, "隧道配置")
-				t.Alignment = text.Middle
-				return t.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 10}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				t := material.Body1(th, "基本配置")
-				t.TextSize = unit.Sp(12)
-				t.Alignment = text.Start
-				return t.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 10}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				e.configNameInputWidget.Input = &Input{
-					gtx:         gtx,
-					th:          th,
-					e:           e,
-					label:       "配置名称：",
-					labelWidth:  80,
-					hint:        "请输入配置名称",
-					hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-					editor:      &e.configNameInput,
-					width:       gtx.Constraints.Max.X,
-					borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+			layout.Rigid(e.layoutTabs),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				if e.IsEditMode() && e.tab == tabStatus {
+					return e.monitor.Layout(gtx, e.window.ui.sidebar.SelectedItem)
 				}
+				return material.List(th, &e.listState).Layout(gtx, 1, func(gtx layout.Context, _ int) layout.Dimensions {
+					return e.layoutForm(gtx)
+				})
+			}),
+		)
+	})
+}
 
-				if e.configNameInputWidget.ValidErr != "" {
-					e.configNameInputWidget.Input.hint = e.configNameInputWidget.ValidErr
-					e.configNameInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-					e.configNameInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-				}
+// layoutTabs switches between the form and the live status of the selected tunnel, new configs only have the form
+func (e *Editor) layoutTabs(gtx layout.Context) layout.Dimensions {
+	th := e.window.th
+	if !e.IsEditMode() {
+		return layout.Dimensions{}
+	}
 
-				return e.configNameInputWidget.Input.Layout()
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 30}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				t := material.Subtitle1(th, "主机配置")
-				t.TextSize = unit.Sp(12)
-				t.Alignment = text.Start
-				return t.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 10}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
-					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-						e.remoteIpInputWidget.Input = &Input{
-							gtx:         gtx,
-							th:          th,
-							e:           e,
-							label:       "主机IP：",
-							labelWidth:  80,
-							hint:        "请输入主机IP",
-							hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-							editor:      &e.remoteIpInput,
-							width:       340,
-							borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
-						}
+	if e.configTab.Clicked(gtx) {
+		e.tab = tabConfig
+	}
+	if e.statusTab.Clicked(gtx) {
+		e.tab = tabStatus
+	}
 
-						if e.remoteIpInputWidget.ValidErr != "" {
-							e.remoteIpInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-							e.remoteIpInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-						}
+	tab := func(clickable *widget.Clickable, label string, active bool) layout.FlexChild {
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			background := color.NRGBA{R: 200, G: 200, B: 200, A: 255}
+			if active {
+				background = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+			}
+			return layout.Inset{Right: 5}.Layout(gtx, smallButton(th, clickable, label, background).Layout)
+		})
+	}
 
-						return e.remoteIpInputWidget.Input.Layout()
-					}),
-					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-						e.remotePortInputWidget.Input = &Input{
-							gtx:         gtx,
-							th:          th,
-							e:           e,
-							label:       "端口：",
-							labelWidth:  60,
-							hint:        "请输入主机端口",
-							hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-							editor:      &e.remotePortInput,
-							width:       190,
-							borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
-						}
+	return layout.Inset{Bottom: 10}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+			tab(&e.configTab, "配置", e.tab == tabConfig),
+			tab(&e.statusTab, "状态", e.tab == tabStatus),
+		)
+	})
+}
 
-						if e.remotePortInputWidget.ValidErr != "" {
-							e.remotePortInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-							e.remotePortInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-						}
+func (e *Editor) layoutForm(gtx layout.Context) layout.Dimensions {
+	th := e.window.th
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			t := material.Body1(th, "隧道配置")
+			t.Alignment = text.Middle
+			return t.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			t := material.Body1(th, "基本配置")
+			t.TextSize = unit.Sp(12)
+			t.Alignment = text.Start
+			return t.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			e.configNameInputWidget.Input = &Input{
+				gtx:         gtx,
+				th:          th,
+				e:           e,
+				label:       "配置名称：",
+				labelWidth:  80,
+				hint:        "请输入配置名称",
+				hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+				editor:      &e.configNameInput,
+				width:       gtx.Constraints.Max.X,
+				borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+			}
 
-						return e.remotePortInputWidget.Input.Layout()
-					}),
-				)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 30}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				t := material.Subtitle1(th, "SSH代理配置")
-				t.Alignment = text.Start
-				t.TextSize = unit.Sp(12)
-				return t.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 10}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
-					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-						e.serverIpInputWidget.Input = &Input{
-							gtx:         gtx,
-							th:          th,
-							e:           e,
-							label:       "主机IP：",
-							labelWidth:  80,
-							hint:        "请输入主机IP",
-							hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-							editor:      &e.serverIpInput,
-							width:       340,
-							borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
-						}
+			if e.configNameInputWidget.ValidErr != "" {
+				e.configNameInputWidget.Input.hint = e.configNameInputWidget.ValidErr
+				e.configNameInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+				e.configNameInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+			}
 
-						if e.serverIpInputWidget.ValidErr != "" {
-							e.serverIpInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-							e.serverIpInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-						}
+			return e.configNameInputWidget.Input.Layout()
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 30}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			t := material.Subtitle1(th, "主机配置")
+			t.TextSize = unit.Sp(12)
+			t.Alignment = text.Start
+			return t.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return e.layoutRadioGroup(gtx, "转发模式：", &e.modeEnum, []radioOption{
+				{key: service.ForwardModeLocal, label: "本地转发"},
+				{key: service.ForwardModeDynamic, label: "动态转发（SOCKS5）"},
+				{key: service.ForwardModeRemote, label: "远程转发"},
+			})
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if e.isDynamicMode() {
+				return e.layoutSocksOptions(gtx)
+			}
 
-						return e.serverIpInputWidget.Input.Layout()
-					}),
-					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-						e.serverPortInputWidget.Input = &Input{
-							gtx:         gtx,
-							th:          th,
-							e:           e,
-							label:       "端口：",
-							labelWidth:  60,
-							hint:        "请输入主机端口",
-							hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-							editor:      &e.serverPortInput,
-							width:       190,
-							borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
-						}
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					e.remoteIpInputWidget.Input = &Input{
+						gtx:         gtx,
+						th:          th,
+						e:           e,
+						label:       "主机IP：",
+						labelWidth:  80,
+						hint:        "请输入主机IP",
+						hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+						editor:      &e.remoteIpInput,
+						width:       340,
+						borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+					}
+
+					if e.isRemoteMode() {
+						e.remoteIpInputWidget.Input.label = "监听IP："
+						e.remoteIpInputWidget.Input.hint = "SSH主机监听地址，默认127.0.0.1"
+					}
+
+					if e.remoteIpInputWidget.ValidErr != "" {
+						e.remoteIpInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+						e.remoteIpInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+					}
+
+					return e.remoteIpInputWidget.Input.Layout()
+				}),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					e.remotePortInputWidget.Input = &Input{
+						gtx:         gtx,
+						th:          th,
+						e:           e,
+						label:       "端口：",
+						labelWidth:  60,
+						hint:        "请输入主机端口",
+						hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+						editor:      &e.remotePortInput,
+						width:       190,
+						borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+					}
+
+					if e.remotePortInputWidget.ValidErr != "" {
+						e.remotePortInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+						e.remotePortInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+					}
+
+					return e.remotePortInputWidget.Input.Layout()
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if !e.isRemoteMode() {
+				return layout.Dimensions{}
+			}
+			return layout.Inset{Top: 5, Left: 90}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				t := material.Caption(th, "SSH主机监听地址收到的连接将转发到下方的本地目标")
+				t.Color = color.NRGBA{R: 120, G: 120, B: 120, A: 255}
+				return t.Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if e.isDynamicMode() {
+				return layout.Dimensions{}
+			}
+			return layout.Inset{Top: 10}.Layout(gtx, e.layoutLocalAddr)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 30}.Layout(gtx)
+		}),
+		layout.Rigid(e.layoutForwards),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 30}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			t := material.Subtitle1(th, "SSH代理配置")
+			t.Alignment = text.Start
+			t.TextSize = unit.Sp(12)
+			return t.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					e.serverIpInputWidget.Input = &Input{
+						gtx:         gtx,
+						th:          th,
+						e:           e,
+						label:       "主机IP：",
+						labelWidth:  80,
+						hint:        "请输入主机IP",
+						hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+						editor:      &e.serverIpInput,
+						width:       340,
+						borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+					}
+
+					if e.serverIpInputWidget.ValidErr != "" {
+						e.serverIpInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+						e.serverIpInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+					}
+
+					return e.serverIpInputWidget.Input.Layout()
+				}),
+				layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+					e.serverPortInputWidget.Input = &Input{
+						gtx:         gtx,
+						th:          th,
+						e:           e,
+						label:       "端口：",
+						labelWidth:  60,
+						hint:        "请输入主机端口",
+						hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+						editor:      &e.serverPortInput,
+						width:       190,
+						borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+					}
+
+					if e.serverPortInputWidget.ValidErr != "" {
+						e.serverPortInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+						e.serverPortInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+					}
+
+					return e.serverPortInputWidget.Input.Layout()
+				}),
+			)
 
-						if e.serverPortInputWidget.ValidErr != "" {
-							e.serverPortInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-							e.serverPortInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-						}
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			e.usernameInputWidget.Input = &Input{
+				gtx:         gtx,
+				th:          th,
+				e:           e,
+				label:       "用户名：",
+				labelWidth:  80,
+				hint:        "请输入用户名",
+				hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+				editor:      &e.usernameInput,
+				width:       gtx.Constraints.Max.X,
+				borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+			}
 
-						return e.serverPortInputWidget.Input.Layout()
-					}),
-				)
+			if e.usernameInputWidget.ValidErr != "" {
+				e.usernameInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+				e.usernameInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+			}
 
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 10}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				e.usernameInputWidget.Input = &Input{
-					gtx:         gtx,
-					th:          th,
-					e:           e,
-					label:       "用户名：",
-					labelWidth:  80,
-					hint:        "请输入用户名",
-					hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-					editor:      &e.usernameInput,
-					width:       gtx.Constraints.Max.X,
-					borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
-				}
+			return e.usernameInputWidget.Input.Layout()
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return e.layoutRadioGroup(gtx, "认证方式：", &e.authTypeEnum, authTypeOptions)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if !e.usesPassword() {
+				return layout.Dimensions{}
+			}
+			return layout.Spacer{Height: 10}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if !e.usesPassword() {
+				return layout.Dimensions{}
+			}
 
-				if e.usernameInputWidget.ValidErr != "" {
-					e.usernameInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-					e.usernameInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-				}
+			if _, c := e.passwordInput.Update(gtx); c {
+				e.passwordChanged = e.passwordInput.Text() != e.originPassword
+			}
 
-				return e.usernameInputWidget.Input.Layout()
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Spacer{Height: 10}.Layout(gtx)
-			}),
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				if _, c := e.passwordInput.Update(gtx); c {
-					e.passwordChanged = e.passwordInput.Text() != e.originPassword
-				}
+			e.passwordInputWidget.Input = &Input{
+				gtx:         gtx,
+				th:          th,
+				e:           e,
+				label:       "密码：",
+				labelWidth:  80,
+				hint:        "请输入密码，或 env:变量名、cmd:命令、file:路径",
+				hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+				editor:      &e.passwordInput,
+				width:       gtx.Constraints.Max.X,
+				borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+			}
 
-				e.passwordInputWidget.Input = &Input{
-					gtx:         gtx,
-					th:          th,
-					e:           e,
-					label:       "密码：",
-					labelWidth:  80,
-					hint:        "请输入密码",
-					hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
-					editor:      &e.passwordInput,
-					width:       gtx.Constraints.Max.X,
-					borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
-				}
+			if e.passwordInputWidget.ValidErr != "" {
+				e.passwordInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+				e.passwordInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+			}
+			return e.passwordInputWidget.Input.Layout()
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if !e.usesPrivateKey() {
+				return layout.Dimensions{}
+			}
 
-				if e.passwordInputWidget.ValidErr != "" {
-					e.passwordInputWidget.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-					e.passwordInputWidget.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-				}
-				return e.passwordInputWidget.Input.Layout()
-			}),
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.privateKeyPathInputWidget, &e.privateKeyPathInput, "私钥文件：", "请输入私钥路径，如 ~/.ssh/id_rsa")
+				}),
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.privateKeyInputWidget, &e.privateKeyInput, "私钥内容：", "或直接粘贴 PEM 格式私钥")
+				}),
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.passphraseInputWidget, &e.passphraseInput, "私钥口令：", "私钥未加密时留空")
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if e.authTypeEnum.Value != service.AuthTypeAgent {
+				return layout.Dimensions{}
+			}
+
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.agentKeyInputWidget, &e.agentKeyInput, "指纹：", "可选，仅使用该指纹的密钥，如 SHA256:...")
+				}),
+			)
+		}),
+		layout.Rigid(layout.Spacer{Height: 10}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+				layout.Flexed(0.5, func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.keepAliveInputWidget, &e.keepAliveInput, "心跳间隔：", "秒，默认30")
+				}),
+				layout.Rigid(layout.Spacer{Width: 10}.Layout),
+				layout.Flexed(0.5, func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.maxMissedInputWidget, &e.maxMissedInput, "断线判定：", "连续丢失次数，默认3")
+				}),
+			)
+		}),
+		layout.Rigid(layout.Spacer{Height: 10}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+				layout.Flexed(0.5, func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.reconnectInputWidget, &e.reconnectInput, "重连次数：", "默认10，0 为不重连")
+				}),
+				layout.Rigid(layout.Spacer{Width: 10}.Layout),
+				layout.Flexed(0.5, func(gtx layout.Context) layout.Dimensions {
+					return layout.Inset{Top: 4}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						cb := material.CheckBox(th, &e.autoStartCheck, "随应用启动")
+						cb.IconColor = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+						return cb.Layout(gtx)
+					})
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: 30}.Layout(gtx)
+		}),
+		layout.Rigid(e.layoutJumpHosts),
 
-			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-				return layout.Inset{Top: 20, Bottom: 20, Left: 50, Right: 50}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceSides}.Layout(gtx,
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Top: 20, Bottom: 20, Left: 50, Right: 50}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceSides}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{
+							Top:    20,
+							Bottom: 20,
+							Left:   50,
+							Right:  50,
+						}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							if e.saveButton.Clicked(gtx) {
+								e.OnSaveBtnClicked(e.window.ctx)
+							}
+							btn := material.Button(th, &e.saveButton, "保存")
+							btn.Inset = layout.Inset{Top: 6, Bottom: 6, Left: 10, Right: 10}
+							btn.Background = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+							return btn.Layout(gtx)
+						})
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if e.IsEditMode() {
 							return layout.Inset{
 								Top:    20,
 								Bottom: 20,
 								Left:   50,
 								Right:  50,
 							}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-								if e.saveButton.Clicked(gtx) {
-									e.OnSaveBtnClicked(e.window.ctx)
+								if e.deleteButton.Clicked(gtx) {
+									e.OnDelBtnClicked(e.window.ctx)
 								}
-								btn := material.Button(th, &e.saveButton, "保存")
-								btn.Inset = layout.Inset{Top: 6, Bottom: 6, Left: 10, Right: 10}
-								btn.Background = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+								btn := material.Button(th, &e.deleteButton, "删除")
+								btn.Background = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+								btn.Inset = layout.Inset{Top: 4, Bottom: 4, Left: 10, Right: 10}
 								return btn.Layout(gtx)
 							})
-						}),
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							if e.IsEditMode() {
-								return layout.Inset{
-									Top:    20,
-									Bottom: 20,
-									Left:   50,
-									Right:  50,
-								}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-									if e.deleteButton.Clicked(gtx) {
-										e.OnDelBtnClicked(e.window.ctx)
-									}
-									btn := material.Button(th, &e.deleteButton, "删除")
-									btn.Background = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
-									btn.Inset = layout.Inset{Top: 4, Bottom: 4, Left: 10, Right: 10}
-									return btn.Layout(gtx)
-								})
-							}
-							return layout.Dimensions{}
-						}),
-					)
-				})
-			}),
-		)
-	})
+						}
+						return layout.Dimensions{}
+					}),
+				)
+			})
+		}),
+	)
 }
 
 type Input struct {
@@ -402,12 +654,122 @@ func (i *Input) Layout() layout.Dimensions {
 	)
 }
 
-func (e *Editor) OnSaveBtnClicked(ctx context.Context) {
-	if err := e.validateForm(); err != nil {
-		log.Printf("form validation error: %s", err)
-		return
+func (e *Editor) layoutInput(gtx layout.Context, iw *InputWidget, editor *widget.Editor, label, hint string) layout.Dimensions {
+	return e.newInput(gtx, iw, editor, label, hint).Layout()
+}
+
+func (e *Editor) newInput(gtx layout.Context, iw *InputWidget, editor *widget.Editor, label, hint string) *Input {
+	iw.Input = &Input{
+		gtx:         gtx,
+		th:          e.window.th,
+		e:           e,
+		label:       label,
+		labelWidth:  80,
+		hint:        hint,
+		hintColor:   color.NRGBA{R: 169, G: 169, B: 169, A: 255},
+		editor:      editor,
+		width:       gtx.Constraints.Max.X,
+		borderColor: color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF},
+	}
+
+	if iw.ValidErr != "" {
+		iw.Input.borderColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+		iw.Input.hintColor = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+	}
+
+	return iw.Input
+}
+
+type radioOption struct {
+	key   string
+	label string
+}
+
+var authTypeOptions = []radioOption{
+	{key: service.AuthTypePassword, label: "密码"},
+	{key: service.AuthTypeKey, label: "私钥"},
+	{key: service.AuthTypeKeyPassword, label: "私钥+密码"},
+	{key: service.AuthTypeAgent, label: "SSH Agent"},
+}
+
+func (e *Editor) layoutRadioGroup(gtx layout.Context, label string, enum *widget.Enum, options []radioOption) layout.Dimensions {
+	th := e.window.th
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints = layout.Exact(image.Pt(80, 30))
+			return layout.UniformInset(5).Layout(gtx, material.Body1(th, label).Layout)
+		}),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+	}
+
+	for _, option := range options {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			rb := material.RadioButton(th, enum, option.key, option.label)
+			rb.IconColor = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+			return rb.Layout(gtx)
+		}))
 	}
 
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx, children...)
+}
+
+func (e *Editor) layoutLocalAddr(gtx layout.Context) layout.Dimensions {
+	ipLabel, ipHint := "本地IP：", "默认127.0.0.1，0.0.0.0 对局域网开放"
+	portHint := "0 为自动分配"
+	if e.isRemoteMode() {
+		ipLabel, ipHint = "目标IP：", "本地服务地址，默认127.0.0.1"
+		portHint = "本地服务端口"
+	}
+
+	return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Max.X = 340
+			return e.layoutInput(gtx, e.localIpInputWidget, &e.localIpInput, ipLabel, ipHint)
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Max.X = 190
+			input := e.newInput(gtx, e.localPortInputWidget, &e.localPortInput, "端口：", portHint)
+			input.labelWidth = 60
+			return input.Layout()
+		}),
+	)
+}
+
+func (e *Editor) layoutSocksOptions(gtx layout.Context) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(e.layoutLocalAddr),
+		layout.Rigid(layout.Spacer{Height: 10}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+				layout.Flexed(0.5, func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.socksUsernameInputWidget, &e.socksUsernameInput, "代理用户：", "可选")
+				}),
+				layout.Rigid(layout.Spacer{Width: 10}.Layout),
+				layout.Flexed(0.5, func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, e.socksPasswordInputWidget, &e.socksPasswordInput, "代理密码：", "可选")
+				}),
+			)
+		}),
+	)
+}
+
+func (e *Editor) isDynamicMode() bool {
+	return e.modeEnum.Value == service.ForwardModeDynamic
+}
+
+func (e *Editor) isRemoteMode() bool {
+	return e.modeEnum.Value == service.ForwardModeRemote
+}
+
+func (e *Editor) usesPassword() bool {
+	return service.UsesPassword(e.authTypeEnum.Value)
+}
+
+func (e *Editor) usesPrivateKey() bool {
+	return service.UsesPrivateKey(e.authTypeEnum.Value)
+}
+
+func (e *Editor) OnSaveBtnClicked(ctx context.Context) {
 	cf := &service.ConfigFile{
 		ConfigName: e.configNameInput.Text(),
 		RemoteIP:   e.remoteIpInput.Text(),
@@ -415,20 +777,68 @@ func (e *Editor) OnSaveBtnClicked(ctx context.Context) {
 		ServerIP:   e.serverIpInput.Text(),
 		ServerPort: e.serverPortInput.Text(),
 		UserName:   e.usernameInput.Text(),
-		Password:   e.passwordInput.Text(),
+		AuthType:   e.authTypeEnum.Value,
+		Mode:       e.modeEnum.Value,
+		LocalIP:    e.localIpInput.Text(),
+		LocalPort:  e.localPortInput.Text(),
+
+		KeepAliveInterval:  e.keepAliveInput.Text(),
+		KeepAliveMaxMissed: e.maxMissedInput.Text(),
+		ReconnectAttempts:  e.reconnectInput.Text(),
+		AutoStart:          e.autoStartCheck.Value,
+	}
+
+	if e.isDynamicMode() {
+		cf.RemoteIP = ""
+		cf.RemotePort = ""
+		cf.SocksUsername = e.socksUsernameInput.Text()
+		cf.SocksPassword = e.socksPasswordInput.Text()
+	}
+
+	if e.usesPassword() {
+		cf.Password = e.passwordInput.Text()
+	}
+
+	if e.usesPrivateKey() {
+		cf.PrivateKeyPath = e.privateKeyPathInput.Text()
+		cf.PrivateKey = e.privateKeyInput.Text()
+		cf.Passphrase = e.passphraseInput.Text()
 	}
 
-	var err error
+	if e.authTypeEnum.Value == service.AuthTypeAgent {
+		cf.AgentFingerprint = e.agentKeyInput.Text()
+	}
+
+	for _, row := range e.jumpHosts {
+		cf.JumpHosts = append(cf.JumpHosts, row.config())
+	}
+
+	for _, row := range e.forwards {
+		cf.Forwards = append(cf.Forwards, row.config())
+	}
 
+	err := cf.Validate()
+	e.showValidation(err)
+	if err != nil {
+		log.Printf("form validation error: %s", err)
+		return
+	}
+
+	store := service.DefaultConfigStore()
 	if e.IsEditMode() {
 		cf.FileName = e.fileName
 		cf.Identifier = e.identifier
-		err = cf.UpdateConfigFile(ctx)
+		err = store.Update(ctx, cf)
 	} else {
-		cf.Identifier = fmt.Sprintf("%d", time.Now().UnixMicro())
-		err = cf.SaveConfigFile(ctx)
+		err = store.Create(ctx, cf)
 	}
 
+	if errors.Is(err, service.ErrSecretStoreLocked) {
+		// the credentials cannot be stored yet, the form is kept for saving again once unlocked
+		log.Printf("save config error: %s", err)
+		go e.window.UnlockSecrets(ctx)
+		return
+	}
 	if err != nil {
 		log.Printf("save config error: %s", err)
 		return
@@ -444,10 +854,11 @@ func (e *Editor) OnSaveBtnClicked(ctx context.Context) {
 
 func (e *Editor) OnDelBtnClicked(ctx context.Context) {
 	cf := &service.ConfigFile{
-		FileName: e.fileName,
+		Identifier: e.identifier,
+		FileName:   e.fileName,
 	}
 
-	if err := cf.DeleteConfigFile(ctx); err != nil {
+	if err := service.DefaultConfigStore().Delete(ctx, cf); err != nil {
 		log.Printf("delete config error: %s", err.Error())
 		return
 	}
@@ -459,58 +870,64 @@ func (e *Editor) OnDelBtnClicked(ctx context.Context) {
 	e.SwitchCreateMode()
 }
 
-func (e *Editor) validateForm() error {
-	hasErr := false
-
-	e.configNameInputWidget.ValidErr = ""
-	if e.configNameInput.Text() == "" {
-		e.configNameInputWidget.ValidErr = "config name is empty"
-		hasErr = true
-	}
-
-	e.remoteIpInputWidget.ValidErr = ""
-	if e.remoteIpInput.Text() == "" {
-		e.remoteIpInputWidget.ValidErr = "remote ip is empty"
-		hasErr = true
+// showValidation puts the messages of ConfigFile.Validate under the inputs they are about, nil clears them
+func (e *Editor) showValidation(err error) {
+	config := map[string][]*InputWidget{
+		"config_name":          {e.configNameInputWidget},
+		"server_ip":            {e.serverIpInputWidget},
+		"server_port":          {e.serverPortInputWidget},
+		"user_name":            {e.usernameInputWidget},
+		"password":             {e.passwordInputWidget},
+		"private_key_path":     {e.privateKeyPathInputWidget, e.privateKeyInputWidget},
+		"keepalive_interval":   {e.keepAliveInputWidget},
+		"keepalive_max_missed": {e.maxMissedInputWidget},
+		"reconnect_attempts":   {e.reconnectInputWidget},
 	}
-
-	e.remotePortInputWidget.ValidErr = ""
-	if e.remotePortInput.Text() == "" {
-		e.remotePortInputWidget.ValidErr = "remote port is empty"
-		hasErr = true
+	// the main rule is the first one of AllForwards
+	rule := map[string][]*InputWidget{
+		"local_ip":    {e.localIpInputWidget},
+		"local_port":  {e.localPortInputWidget},
+		"remote_ip":   {e.remoteIpInputWidget},
+		"remote_port": {e.remotePortInputWidget},
 	}
 
-	e.serverIpInputWidget.ValidErr = ""
-	if e.serverIpInput.Text() == "" {
-		e.serverIpInputWidget.ValidErr = "server ip is empty"
-		hasErr = true
+	groups := []map[string][]*InputWidget{config, rule}
+	for _, row := range e.jumpHosts {
+		groups = append(groups, row.widgets())
 	}
-	e.serverPortInputWidget.ValidErr = ""
-	if e.serverPortInput.Text() == "" {
-		e.serverPortInputWidget.ValidErr = "server port is empty"
-		hasErr = true
+	for _, row := range e.forwards {
+		groups = append(groups, row.widgets())
 	}
-
-	e.usernameInputWidget.ValidErr = ""
-	if e.usernameInput.Text() == "" {
-		e.usernameInputWidget.ValidErr = "username is empty"
-		hasErr = true
+	for _, group := range groups {
+		for _, widgets := range group {
+			for _, widget := range widgets {
+				widget.ValidErr = ""
+			}
+		}
 	}
 
-	e.passwordInputWidget.ValidErr = ""
-	if e.passwordInput.Text() == "" {
-		e.passwordInputWidget.ValidErr = "password is empty"
-		hasErr = true
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return
 	}
-
-	if hasErr {
-		return fmt.Errorf("form validation error")
+	for _, field := range validationErr.Fields {
+		group := config
+		switch {
+		case field.JumpHost >= 0 && field.JumpHost < len(e.jumpHosts):
+			group = e.jumpHosts[field.JumpHost].widgets()
+		case field.Forward == 0:
+			group = rule
+		case field.Forward > 0 && field.Forward <= len(e.forwards):
+			group = e.forwards[field.Forward-1].widgets()
+		}
+		for _, widget := range group[field.Field] {
+			widget.ValidErr = field.Message
+		}
 	}
-
-	return nil
 }
 
 func (e *Editor) SwitchCreateMode() {
+	e.tab = tabConfig
 	if !e.IsCreateMode() {
 		e.mode = ModeCreate
 		e.setCurItem()
@@ -541,6 +958,41 @@ func (e *Editor) setCurItem() {
 	e.serverPortInput.SetText(config.ServerPort)
 	e.usernameInput.SetText(config.UserName)
 	e.passwordInput.SetText(config.Password)
+	e.privateKeyPathInput.SetText(config.PrivateKeyPath)
+	e.privateKeyInput.SetText(config.PrivateKey)
+	e.passphraseInput.SetText(config.Passphrase)
+	e.agentKeyInput.SetText(config.AgentFingerprint)
+	e.socksUsernameInput.SetText(config.SocksUsername)
+	e.socksPasswordInput.SetText(config.SocksPassword)
+	e.localIpInput.SetText(config.LocalIP)
+	e.localPortInput.SetText(config.LocalPort)
+	e.keepAliveInput.SetText(config.KeepAliveInterval)
+	e.maxMissedInput.SetText(config.KeepAliveMaxMissed)
+	e.reconnectInput.SetText(config.ReconnectAttempts)
+	e.autoStartCheck.Value = config.AutoStart
+	if config.LocalPort == "" {
+		e.localPortInput.SetText(config.RemotePort)
+	}
+
+	e.modeEnum.Value = config.Mode
+	if e.modeEnum.Value == "" {
+		e.modeEnum.Value = service.ForwardModeLocal
+	}
+
+	e.authTypeEnum.Value = config.AuthType
+	if e.authTypeEnum.Value == "" {
+		e.authTypeEnum.Value = service.AuthTypePassword
+	}
+
+	e.jumpHosts = make([]*jumpHostRow, 0, len(config.JumpHosts))
+	for _, hop := range config.JumpHosts {
+		e.jumpHosts = append(e.jumpHosts, newJumpHostRow(hop))
+	}
+
+	e.forwards = make([]*forwardRow, 0, len(config.Forwards))
+	for _, forward := range config.Forwards {
+		e.forwards = append(e.forwards, newForwardRow(forward))
+	}
 }
 
 func (e *Editor) IsCreateMode() bool {
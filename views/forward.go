@@ -0,0 +1,174 @@
+package views
+
+import (
+	"fmt"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image/color"
+	"xtunnel/service"
+)
+
+var forwardModeOptions = []radioOption{
+	{key: service.ForwardModeLocal, label: "本地"},
+	{key: service.ForwardModeDynamic, label: "动态"},
+	{key: service.ForwardModeRemote, label: "远程"},
+}
+
+type forwardRow struct {
+	modeEnum        widget.Enum
+	localIpInput    widget.Editor
+	localPortInput  widget.Editor
+	remoteIpInput   widget.Editor
+	remotePortInput widget.Editor
+	removeButton    widget.Clickable
+
+	localIpInputWidget    *InputWidget
+	localPortInputWidget  *InputWidget
+	remoteIpInputWidget   *InputWidget
+	remotePortInputWidget *InputWidget
+}
+
+func newForwardRow(config *service.ForwardConfig) *forwardRow {
+	row := &forwardRow{
+		modeEnum:              widget.Enum{Value: service.ForwardModeLocal},
+		localIpInputWidget:    &InputWidget{Input: &Input{}},
+		localPortInputWidget:  &InputWidget{Input: &Input{}},
+		remoteIpInputWidget:   &InputWidget{Input: &Input{}},
+		remotePortInputWidget: &InputWidget{Input: &Input{}},
+	}
+
+	if config == nil {
+		return row
+	}
+
+	row.localIpInput.SetText(config.LocalIP)
+	row.localPortInput.SetText(config.LocalPort)
+	row.remoteIpInput.SetText(config.RemoteIP)
+	row.remotePortInput.SetText(config.RemotePort)
+	if config.Mode != "" {
+		row.modeEnum.Value = config.Mode
+	}
+
+	return row
+}
+
+func (r *forwardRow) config() *service.ForwardConfig {
+	config := &service.ForwardConfig{
+		Mode:      r.modeEnum.Value,
+		LocalIP:   r.localIpInput.Text(),
+		LocalPort: r.localPortInput.Text(),
+	}
+
+	if r.modeEnum.Value != service.ForwardModeDynamic {
+		config.RemoteIP = r.remoteIpInput.Text()
+		config.RemotePort = r.remotePortInput.Text()
+	}
+
+	return config
+}
+
+// widgets finds the inputs of the row by the json keys ConfigFile.Validate reports
+func (r *forwardRow) widgets() map[string][]*InputWidget {
+	return map[string][]*InputWidget{
+		"local_ip":    {r.localIpInputWidget},
+		"local_port":  {r.localPortInputWidget},
+		"remote_ip":   {r.remoteIpInputWidget},
+		"remote_port": {r.remotePortInputWidget},
+	}
+}
+
+func (e *Editor) layoutForwards(gtx layout.Context) layout.Dimensions {
+	th := e.window.th
+
+	if e.addForwardButton.Clicked(gtx) {
+		e.forwards = append(e.forwards, newForwardRow(nil))
+	}
+
+	for i := 0; i < len(e.forwards); i++ {
+		if e.forwards[i].removeButton.Clicked(gtx) {
+			e.forwards = append(e.forwards[:i], e.forwards[i+1:]...)
+			i--
+		}
+	}
+
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					t := material.Subtitle1(th, "更多转发规则（共用同一 SSH 连接）")
+					t.TextSize = unit.Sp(12)
+					return t.Layout(gtx)
+				}),
+				layout.Rigid(smallButton(th, &e.addForwardButton, "添加规则", color.NRGBA{R: 0, G: 122, B: 255, A: 255}).Layout),
+			)
+		}),
+	}
+
+	for i, row := range e.forwards {
+		children = append(children,
+			layout.Rigid(layout.Spacer{Height: 10}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return row.Layout(gtx, e, i)
+			}),
+		)
+	}
+
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+func (r *forwardRow) Layout(gtx layout.Context, e *Editor, index int) layout.Dimensions {
+	th := e.window.th
+	border := widget.Border{
+		Color:        color.NRGBA{R: 0xC0, G: 0xC0, B: 0xC0, A: 0xFF},
+		Width:        unit.Dp(1),
+		CornerRadius: unit.Dp(4),
+	}
+
+	localLabel, remoteLabel := "本地：", "远程："
+	if r.modeEnum.Value == service.ForwardModeRemote {
+		localLabel, remoteLabel = "目标：", "监听："
+	}
+
+	addrRow := func(gtx layout.Context, label string, ip *widget.Editor, ipWidget *InputWidget, port *widget.Editor, portWidget *InputWidget) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+			layout.Flexed(0.65, func(gtx layout.Context) layout.Dimensions {
+				return e.layoutInput(gtx, ipWidget, ip, label, "IP，默认127.0.0.1")
+			}),
+			layout.Rigid(layout.Spacer{Width: 10}.Layout),
+			layout.Flexed(0.35, func(gtx layout.Context) layout.Dimensions {
+				input := e.newInput(gtx, portWidget, port, "端口：", "端口")
+				input.labelWidth = 50
+				return input.Layout()
+			}),
+		)
+	}
+
+	return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(8).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+							return e.layoutRadioGroup(gtx, fmt.Sprintf("规则 %d：", index+2), &r.modeEnum, forwardModeOptions)
+						}),
+						layout.Rigid(smallButton(th, &r.removeButton, "删除", color.NRGBA{R: 255, G: 0, B: 0, A: 255}).Layout),
+					)
+				}),
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return addrRow(gtx, localLabel, &r.localIpInput, r.localIpInputWidget, &r.localPortInput, r.localPortInputWidget)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if r.modeEnum.Value == service.ForwardModeDynamic {
+						return layout.Dimensions{}
+					}
+					return layout.Inset{Top: 10}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return addrRow(gtx, remoteLabel, &r.remoteIpInput, r.remoteIpInputWidget, &r.remotePortInput, r.remotePortInputWidget)
+					})
+				}),
+			)
+		})
+	})
+}
@@ -0,0 +1,249 @@
+package views
+
+import (
+	"fmt"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image/color"
+	"xtunnel/service"
+)
+
+type jumpHostRow struct {
+	ipInput             widget.Editor
+	portInput           widget.Editor
+	usernameInput       widget.Editor
+	passwordInput       widget.Editor
+	privateKeyPathInput widget.Editor
+	passphraseInput     widget.Editor
+	agentKeyInput       widget.Editor
+	authTypeEnum        widget.Enum
+	upButton            widget.Clickable
+	downButton          widget.Clickable
+	removeButton        widget.Clickable
+
+	// privateKey is kept as loaded, inline keys of jump hosts are not editable in the form
+	privateKey string
+
+	ipInputWidget             *InputWidget
+	portInputWidget           *InputWidget
+	usernameInputWidget       *InputWidget
+	passwordInputWidget       *InputWidget
+	privateKeyPathInputWidget *InputWidget
+	passphraseInputWidget     *InputWidget
+	agentKeyInputWidget       *InputWidget
+}
+
+func newJumpHostRow(config *service.JumpHostConfig) *jumpHostRow {
+	row := &jumpHostRow{
+		authTypeEnum:              widget.Enum{Value: service.AuthTypePassword},
+		ipInputWidget:             &InputWidget{Input: &Input{}},
+		portInputWidget:           &InputWidget{Input: &Input{}},
+		usernameInputWidget:       &InputWidget{Input: &Input{}},
+		passwordInputWidget:       &InputWidget{Input: &Input{}},
+		privateKeyPathInputWidget: &InputWidget{Input: &Input{}},
+		passphraseInputWidget:     &InputWidget{Input: &Input{}},
+		agentKeyInputWidget:       &InputWidget{Input: &Input{}},
+	}
+
+	if config == nil {
+		row.portInput.SetText("22")
+		return row
+	}
+
+	row.ipInput.SetText(config.IP)
+	row.portInput.SetText(config.Port)
+	row.usernameInput.SetText(config.UserName)
+	row.passwordInput.SetText(config.Password)
+	row.privateKeyPathInput.SetText(config.PrivateKeyPath)
+	row.passphraseInput.SetText(config.Passphrase)
+	row.agentKeyInput.SetText(config.AgentFingerprint)
+	row.privateKey = config.PrivateKey
+	if config.AuthType != "" {
+		row.authTypeEnum.Value = config.AuthType
+	}
+
+	return row
+}
+
+func (r *jumpHostRow) config() *service.JumpHostConfig {
+	config := &service.JumpHostConfig{
+		IP:       r.ipInput.Text(),
+		Port:     r.portInput.Text(),
+		UserName: r.usernameInput.Text(),
+		AuthType: r.authTypeEnum.Value,
+	}
+
+	if r.usesPassword() {
+		config.Password = r.passwordInput.Text()
+	}
+
+	if r.usesPrivateKey() {
+		config.PrivateKeyPath = r.privateKeyPathInput.Text()
+		config.PrivateKey = r.privateKey
+		config.Passphrase = r.passphraseInput.Text()
+	}
+
+	if r.authTypeEnum.Value == service.AuthTypeAgent {
+		config.AgentFingerprint = r.agentKeyInput.Text()
+	}
+
+	return config
+}
+
+func (r *jumpHostRow) usesPassword() bool {
+	return service.UsesPassword(r.authTypeEnum.Value)
+}
+
+func (r *jumpHostRow) usesPrivateKey() bool {
+	return service.UsesPrivateKey(r.authTypeEnum.Value)
+}
+
+// widgets finds the inputs of the row by the json keys ConfigFile.Validate reports
+func (r *jumpHostRow) widgets() map[string][]*InputWidget {
+	return map[string][]*InputWidget{
+		"ip":               {r.ipInputWidget},
+		"port":             {r.portInputWidget},
+		"user_name":        {r.usernameInputWidget},
+		"password":         {r.passwordInputWidget},
+		"private_key_path": {r.privateKeyPathInputWidget},
+	}
+}
+
+func (e *Editor) layoutJumpHosts(gtx layout.Context) layout.Dimensions {
+	th := e.window.th
+
+	if e.addJumpHostButton.Clicked(gtx) {
+		e.jumpHosts = append(e.jumpHosts, newJumpHostRow(nil))
+	}
+
+	for i := 0; i < len(e.jumpHosts); i++ {
+		row := e.jumpHosts[i]
+		switch {
+		case row.removeButton.Clicked(gtx):
+			e.jumpHosts = append(e.jumpHosts[:i], e.jumpHosts[i+1:]...)
+			i--
+		case row.upButton.Clicked(gtx) && i > 0:
+			e.jumpHosts[i-1], e.jumpHosts[i] = e.jumpHosts[i], e.jumpHosts[i-1]
+		case row.downButton.Clicked(gtx) && i < len(e.jumpHosts)-1:
+			e.jumpHosts[i], e.jumpHosts[i+1] = e.jumpHosts[i+1], e.jumpHosts[i]
+			i++
+		}
+	}
+
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					t := material.Subtitle1(th, "跳板机配置（按顺序连接，最后连接 SSH 代理主机）")
+					t.TextSize = unit.Sp(12)
+					return t.Layout(gtx)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return smallButton(th, &e.addJumpHostButton, "添加跳板机", color.NRGBA{R: 0, G: 122, B: 255, A: 255}).Layout(gtx)
+				}),
+			)
+		}),
+	}
+
+	for i, row := range e.jumpHosts {
+		children = append(children,
+			layout.Rigid(layout.Spacer{Height: 10}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return row.Layout(gtx, e, i)
+			}),
+		)
+	}
+
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+func (r *jumpHostRow) Layout(gtx layout.Context, e *Editor, index int) layout.Dimensions {
+	th := e.window.th
+	border := widget.Border{
+		Color:        color.NRGBA{R: 0xC0, G: 0xC0, B: 0xC0, A: 0xFF},
+		Width:        unit.Dp(1),
+		CornerRadius: unit.Dp(4),
+	}
+
+	return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.UniformInset(8).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(material.Body2(th, fmt.Sprintf("跳板机 %d", index+1)).Layout),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+								layout.Rigid(smallButton(th, &r.upButton, "上移", color.NRGBA{R: 120, G: 120, B: 120, A: 255}).Layout),
+								layout.Rigid(layout.Spacer{Width: 5}.Layout),
+								layout.Rigid(smallButton(th, &r.downButton, "下移", color.NRGBA{R: 120, G: 120, B: 120, A: 255}).Layout),
+								layout.Rigid(layout.Spacer{Width: 5}.Layout),
+								layout.Rigid(smallButton(th, &r.removeButton, "删除", color.NRGBA{R: 255, G: 0, B: 0, A: 255}).Layout),
+							)
+						}),
+					)
+				}),
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween}.Layout(gtx,
+						layout.Flexed(0.6, func(gtx layout.Context) layout.Dimensions {
+							return e.layoutInput(gtx, r.ipInputWidget, &r.ipInput, "主机IP：", "请输入跳板机IP")
+						}),
+						layout.Rigid(layout.Spacer{Width: 10}.Layout),
+						layout.Flexed(0.4, func(gtx layout.Context) layout.Dimensions {
+							return e.layoutInput(gtx, r.portInputWidget, &r.portInput, "端口：", "请输入端口")
+						}),
+					)
+				}),
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return e.layoutInput(gtx, r.usernameInputWidget, &r.usernameInput, "用户名：", "请输入用户名")
+				}),
+				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return e.layoutRadioGroup(gtx, "认证方式：", &r.authTypeEnum, authTypeOptions)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if !r.usesPassword() {
+						return layout.Dimensions{}
+					}
+					return layout.Inset{Top: 10}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return e.layoutInput(gtx, r.passwordInputWidget, &r.passwordInput, "密码：", "请输入密码，或 env:变量名、cmd:命令、file:路径")
+					})
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if !r.usesPrivateKey() {
+						return layout.Dimensions{}
+					}
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+						layout.Rigid(layout.Spacer{Height: 10}.Layout),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return e.layoutInput(gtx, r.privateKeyPathInputWidget, &r.privateKeyPathInput, "私钥文件：", "请输入私钥路径，如 ~/.ssh/id_rsa")
+						}),
+						layout.Rigid(layout.Spacer{Height: 10}.Layout),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return e.layoutInput(gtx, r.passphraseInputWidget, &r.passphraseInput, "私钥口令：", "私钥未加密时留空")
+						}),
+					)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if r.authTypeEnum.Value != service.AuthTypeAgent {
+						return layout.Dimensions{}
+					}
+					return layout.Inset{Top: 10}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return e.layoutInput(gtx, r.agentKeyInputWidget, &r.agentKeyInput, "指纹：", "可选，仅使用该指纹的密钥，如 SHA256:...")
+					})
+				}),
+			)
+		})
+	})
+}
+
+func smallButton(th *material.Theme, clickable *widget.Clickable, label string, background color.NRGBA) material.ButtonStyle {
+	btn := material.Button(th, clickable, label)
+	btn.TextSize = unit.Sp(12)
+	btn.Inset = layout.Inset{Top: 2, Bottom: 2, Left: 8, Right: 8}
+	btn.Background = background
+	return btn
+}
@@ -0,0 +1,252 @@
+package views
+
+import (
+	"fmt"
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image"
+	"image/color"
+	"time"
+	"xtunnel/service"
+)
+
+const (
+	monitorSampleInterval = 1 * time.Second
+	monitorSamples        = 60
+	monitorMaxConns       = 50
+)
+
+var (
+	sentColor     = color.NRGBA{R: 0, G: 122, B: 255, A: 255}
+	receivedColor = color.NRGBA{R: 52, G: 168, B: 83, A: 255}
+	mutedColor    = color.NRGBA{R: 120, G: 120, B: 120, A: 255}
+	errorColor    = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+)
+
+// Monitor shows the live statistics of the selected tunnel, the throughput history restarts when the selection changes
+type Monitor struct {
+	window    *Window
+	listState widget.List
+
+	identifier   string
+	sampledAt    time.Time
+	lastSent     int64
+	lastReceived int64
+	sentRates    []float64
+	recvRates    []float64
+}
+
+func NewMonitor(w *Window) *Monitor {
+	return &Monitor{
+		window:    w,
+		listState: widget.List{List: layout.List{Axis: layout.Vertical}},
+	}
+}
+
+func (m *Monitor) Layout(gtx layout.Context, item *SidebarItem) layout.Dimensions {
+	th := m.window.th
+	if item == nil {
+		return layout.Dimensions{}
+	}
+
+	stats, err := m.window.ui.sidebar.tunnelManager.Stats(m.window.ctx, item.config.Identifier)
+	if err != nil {
+		return material.Body2(th, err.Error()).Layout(gtx)
+	}
+
+	m.sample(item.config.Identifier, stats)
+	gtx.Execute(op.InvalidateCmd{At: m.sampledAt.Add(monitorSampleInterval)})
+
+	rows := []layout.Widget{
+		func(gtx layout.Context) layout.Dimensions {
+			return m.layoutField(gtx, "状态：", statusText(stats.Status), mutedColor)
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			uptime := "-"
+			if stats.Uptime > 0 {
+				uptime = stats.Uptime.Truncate(time.Second).String()
+			}
+			return m.layoutField(gtx, "运行时长：", uptime, mutedColor)
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			return m.layoutField(gtx, "累计流量：", fmt.Sprintf("发送 %s，接收 %s", formatBytes(stats.BytesSent), formatBytes(stats.BytesReceived)), mutedColor)
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			return m.layoutField(gtx, "当前速率：", fmt.Sprintf("↑ %s/s，↓ %s/s", formatBytes(int64(last(m.sentRates))), formatBytes(int64(last(m.recvRates)))), mutedColor)
+		},
+		m.layoutSparkline,
+		func(gtx layout.Context) layout.Dimensions {
+			return m.layoutField(gtx, "连接：", fmt.Sprintf("活动 %d，累计 %d，拨号失败 %d", stats.ActiveConns, stats.TotalAccepted, stats.DialFailures), mutedColor)
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			lastError := "无"
+			textColor := mutedColor
+			if stats.LastError != "" {
+				lastError = fmt.Sprintf("%s %s", stats.LastErrorAt.Format("15:04:05"), stats.LastError)
+				textColor = errorColor
+			}
+			return m.layoutField(gtx, "最近错误：", lastError, textColor)
+		},
+		func(gtx layout.Context) layout.Dimensions {
+			t := material.Body1(th, "活动连接")
+			t.TextSize = unit.Sp(12)
+			return layout.Inset{Top: 10}.Layout(gtx, t.Layout)
+		},
+	}
+
+	conns := stats.Connections
+	if len(conns) > monitorMaxConns {
+		conns = conns[len(conns)-monitorMaxConns:]
+	}
+	for _, conn := range conns {
+		rows = append(rows, func(gtx layout.Context) layout.Dimensions {
+			text := fmt.Sprintf("%s → %s  ↑ %s ↓ %s  %s", conn.Client, conn.Target, formatBytes(conn.BytesSent), formatBytes(conn.BytesReceived), time.Since(conn.OpenedAt).Truncate(time.Second))
+			t := material.Caption(th, text)
+			t.Color = mutedColor
+			t.MaxLines = 1
+			return t.Layout(gtx)
+		})
+	}
+	if len(conns) == 0 {
+		rows = append(rows, func(gtx layout.Context) layout.Dimensions {
+			t := material.Caption(th, "暂无连接")
+			t.Color = mutedColor
+			return t.Layout(gtx)
+		})
+	}
+
+	return material.List(th, &m.listState).Layout(gtx, len(rows), func(gtx layout.Context, index int) layout.Dimensions {
+		return layout.Inset{Bottom: 8}.Layout(gtx, rows[index])
+	})
+}
+
+// sample turns the byte totals into per second rates, at most once per monitorSampleInterval
+func (m *Monitor) sample(identifier string, stats service.TunnelStats) {
+	now := time.Now()
+	if identifier != m.identifier || stats.BytesSent < m.lastSent || stats.BytesReceived < m.lastReceived {
+		m.identifier = identifier
+		m.sentRates, m.recvRates = nil, nil
+		m.sampledAt = now
+		m.lastSent, m.lastReceived = stats.BytesSent, stats.BytesReceived
+		return
+	}
+
+	elapsed := now.Sub(m.sampledAt)
+	if elapsed < monitorSampleInterval {
+		return
+	}
+
+	seconds := elapsed.Seconds()
+	m.sentRates = appendSample(m.sentRates, float64(stats.BytesSent-m.lastSent)/seconds)
+	m.recvRates = appendSample(m.recvRates, float64(stats.BytesReceived-m.lastReceived)/seconds)
+	m.sampledAt = now
+	m.lastSent, m.lastReceived = stats.BytesSent, stats.BytesReceived
+}
+
+func (m *Monitor) layoutField(gtx layout.Context, label, value string, valueColor color.NRGBA) layout.Dimensions {
+	th := m.window.th
+	return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = 80
+			return material.Body2(th, label).Layout(gtx)
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			t := material.Body2(th, value)
+			t.Color = valueColor
+			return t.Layout(gtx)
+		}),
+	)
+}
+
+// layoutSparkline draws the send and receive rates of the last monitorSamples seconds on a shared scale
+func (m *Monitor) layoutSparkline(gtx layout.Context) layout.Dimensions {
+	size := image.Pt(gtx.Constraints.Max.X, gtx.Dp(80))
+	border := widget.Border{Color: color.NRGBA{R: 0xC0, G: 0xC0, B: 0xC0, A: 0xFF}, Width: unit.Dp(1), CornerRadius: unit.Dp(4)}
+
+	return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		peak := 1.0
+		for _, rate := range append(append([]float64{}, m.sentRates...), m.recvRates...) {
+			peak = max(peak, rate)
+		}
+
+		for _, line := range []struct {
+			rates []float64
+			color color.NRGBA
+		}{{m.sentRates, sentColor}, {m.recvRates, receivedColor}} {
+			if len(line.rates) < 2 {
+				continue
+			}
+
+			step := float32(size.X) / float32(monitorSamples-1)
+			offset := float32(monitorSamples-len(line.rates)) * step
+			height := float32(size.Y - 4)
+
+			var path clip.Path
+			path.Begin(gtx.Ops)
+			for i, rate := range line.rates {
+				point := f32.Pt(offset+float32(i)*step, 2+height-float32(rate/peak)*height)
+				if i == 0 {
+					path.MoveTo(point)
+					continue
+				}
+				path.LineTo(point)
+			}
+			paint.FillShape(gtx.Ops, line.color, clip.Stroke{Path: path.End(), Width: float32(gtx.Dp(1.5))}.Op())
+		}
+
+		return layout.Dimensions{Size: size}
+	})
+}
+
+func appendSample(samples []float64, sample float64) []float64 {
+	samples = append(samples, sample)
+	if len(samples) > monitorSamples {
+		samples = samples[len(samples)-monitorSamples:]
+	}
+	return samples
+}
+
+func last(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	return samples[len(samples)-1]
+}
+
+func statusText(status service.TunnelStatus) string {
+	switch status {
+	case service.StatusStarting:
+		return "启动中"
+	case service.StatusRunning:
+		return "运行中"
+	case service.StatusStopping:
+		return "停止中"
+	case service.StatusReconnecting:
+		return "重连中"
+	case service.StatusFailed:
+		return "已失败"
+	default:
+		return "已停止"
+	}
+}
+
+func formatBytes(n int64) string {
+	const kib = 1024
+	if n < kib {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value, suffix := float64(n), "KMGTPE"
+	i := -1
+	for value >= kib && i < len(suffix)-1 {
+		value /= kib
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, suffix[i])
+}
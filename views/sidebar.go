@@ -2,6 +2,7 @@ package views
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gioui.org/layout"
 	"gioui.org/op/paint"
@@ -11,6 +12,8 @@ import (
 	"image"
 	"image/color"
 	"log"
+	"path/filepath"
+	"strings"
 	"xtunnel/service"
 )
 
@@ -21,6 +24,10 @@ type Sidebar struct {
 	tunnelManager *service.TunnelManager
 	listState     *widget.List
 	createBtn     *widget.Clickable
+	// autoStarted holds the tunnels autostart already handled, one the user switched off stays off across reloads
+	autoStarted map[string]bool
+	// reported holds the corrupt config files the user was told about already
+	reported map[string]bool
 }
 
 type SidebarItem struct {
@@ -28,45 +35,46 @@ type SidebarItem struct {
 	tunnel       *service.Tunnel
 	clickWidget  widget.Clickable
 	switchWidget widget.Bool
+	lastStatus   service.TunnelStatus
 }
 
 func (s *Sidebar) LoadSidebarItems(ctx context.Context) error {
-	cf := &service.ConfigFile{}
-	files, err := cf.LoadConfigFile(ctx)
-	if err != nil {
+	files, err := service.DefaultConfigStore().Load(ctx)
+	var loadErr *service.ConfigLoadError
+	if errors.As(err, &loadErr) {
+		s.reportCorrupt(loadErr)
+	} else if err != nil {
 		log.Panicf("loading config file err: %s", err.Error())
 		return err
 	}
 
-	tunnelManager := service.NewTunnelManager()
-	items := make([]*SidebarItem, len(files))
-	for i, file := range files {
-		_, err := tunnelManager.AddTunnel(
-			ctx,
-			file.Identifier,
-			&service.TunnelConfig{
-				Username:   file.UserName,
-				Password:   file.Password,
-				LocalAddr:  fmt.Sprintf("127.0.0.1:%s", file.RemotePort),
-				ServerAddr: fmt.Sprintf("%s:%s", file.ServerIP, file.ServerPort),
-				RemoteAddr: fmt.Sprintf("%s:%s", file.RemoteIP, file.RemotePort),
-			},
-		)
-
-		if err != nil {
-			log.Printf("add tunnel err: %s", err.Error())
-			continue
-		}
+	// the manager outlives reloads, tunnels keep running and only follow the changed configs
+	previous := make(map[string]*SidebarItem, len(s.items))
+	for _, item := range s.items {
+		previous[item.config.Identifier] = item
+	}
 
-		items[i] = &SidebarItem{
+	files = s.tunnelManager.SyncConfigs(ctx, files)
+	items := make([]*SidebarItem, 0, len(files))
+	for _, file := range files {
+		item := &SidebarItem{
 			config:       file,
 			switchWidget: widget.Bool{Value: false},
 			clickWidget:  widget.Clickable{},
 		}
+		if prev, ok := previous[file.Identifier]; ok {
+			item.switchWidget.Value = prev.switchWidget.Value
+			item.lastStatus = prev.lastStatus
+		}
+		// tunnels with stored credentials wait for the secret store to be unlocked
+		if file.AutoStart && !s.autoStarted[file.Identifier] && !(file.HasSecretRefs() && service.Secrets.Locked()) {
+			s.autoStarted[file.Identifier] = true
+			s.autoStart(item)
+		}
+		items = append(items, item)
 	}
 
 	s.items = items
-	s.tunnelManager = tunnelManager
 
 	if len(items) > 0 {
 		s.SelectedItem = items[0]
@@ -75,13 +83,53 @@ func (s *Sidebar) LoadSidebarItems(ctx context.Context) error {
 	return nil
 }
 
+// reportCorrupt tells the user about config files which cannot be read, each file only once
+func (s *Sidebar) reportCorrupt(loadErr *service.ConfigLoadError) {
+	lines := make([]string, 0, len(loadErr.Files))
+	for _, file := range loadErr.Files {
+		if s.reported[file.Path] {
+			continue
+		}
+		s.reported[file.Path] = true
+
+		line := fmt.Sprintf("%s：%s", filepath.Base(file.Path), file.Err.Error())
+		if file.Backup != "" {
+			line += fmt.Sprintf("\n上一版本保存在 %s，可用它覆盖损坏的文件。", file.Backup)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	message := "以下配置文件无法加载：\n" + strings.Join(lines, "\n")
+	go s.window.Alert(s.window.ctx, "配置文件无法加载", message, "知道了")
+}
+
+// autoStart turns the switch on together with the tunnel, a tunnel already started elsewhere is left alone
+func (s *Sidebar) autoStart(item *SidebarItem) {
+	if err := s.tunnelManager.StartTunnel(s.window.ctx, item.config.Identifier); err != nil {
+		log.Printf("autostart tunnel err: %s", err.Error())
+		return
+	}
+	item.switchWidget.Value = true
+}
+
 func NewSidebar(w *Window) *Sidebar {
 	sidebar := &Sidebar{
-		window:    w,
-		createBtn: &widget.Clickable{},
-		listState: &widget.List{List: layout.List{Axis: layout.Vertical}},
+		window:        w,
+		createBtn:     &widget.Clickable{},
+		listState:     &widget.List{List: layout.List{Axis: layout.Vertical}},
+		tunnelManager: service.NewTunnelManager(),
+		autoStarted:   make(map[string]bool),
+		reported:      make(map[string]bool),
 	}
 
+	sidebar.tunnelManager.SetHostKeyConfirm(sidebar.confirmHostKey)
+	sidebar.tunnelManager.OnEvent(func(event service.Event) {
+		w.window.Invalidate()
+	})
+
 	if err := sidebar.LoadSidebarItems(w.ctx); err != nil {
 		log.Printf("LoadSidebarItems err: %s", err.Error())
 	}
@@ -89,6 +137,104 @@ func NewSidebar(w *Window) *Sidebar {
 	return sidebar
 }
 
+// reload rebuilds the items after the config store changed outside the editor, the selection is kept when possible
+func (s *Sidebar) reload(ctx context.Context) {
+	selected := ""
+	if s.SelectedItem != nil {
+		selected = s.SelectedItem.config.Identifier
+	}
+
+	if err := s.LoadSidebarItems(ctx); err != nil {
+		log.Printf("LoadSidebarItems err: %s", err.Error())
+		return
+	}
+
+	s.SelectedItem = nil
+	for _, item := range s.items {
+		if item.config.Identifier == selected {
+			s.SelectedItem = item
+		}
+	}
+	if s.SelectedItem == nil && len(s.items) > 0 {
+		s.SelectedItem = s.items[0]
+	}
+
+	editor := s.window.ui.editor
+	switch {
+	case !editor.IsEditMode():
+	case s.SelectedItem == nil:
+		editor.SwitchCreateMode()
+	case s.SelectedItem.config.Identifier != selected:
+		editor.SwitchEditMode()
+	}
+}
+
+func (s *Sidebar) confirmHostKey(ctx context.Context, host string, keyType string, fingerprint string) bool {
+	message := fmt.Sprintf("首次连接主机 %s，无法确认其身份。\n%s 密钥指纹：%s\n确认信任并记录该主机密钥？", host, keyType, fingerprint)
+	return s.window.Confirm(ctx, "验证主机密钥", message, "信任", "拒绝")
+}
+
+func (s *Sidebar) tunnelAddr(item *SidebarItem) string {
+	addrs, err := s.tunnelManager.ListenAddrs(s.window.ctx, item.config.Identifier)
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+
+	forwards := item.config.AllForwards()
+	lines := make([]string, 0, len(addrs))
+	for i, addr := range addrs {
+		if i < len(forwards) && forwards[i].Mode == service.ForwardModeRemote {
+			lines = append(lines, fmt.Sprintf("远程 %s → %s", addr, forwards[i].LocalAddr()))
+			continue
+		}
+		lines = append(lines, addr)
+	}
+
+	return fmt.Sprintf("监听 %s", strings.Join(lines, ", "))
+}
+
+// syncSwitch follows status changes the user did not make from the switch, like a failure or a tunnel started elsewhere.
+// A stopped tunnel only turns the switch off when it was running before, the switch leads while a start is pending.
+func (s *Sidebar) syncSwitch(item *SidebarItem, status service.TunnelStatus) {
+	switch status {
+	case service.StatusStarting, service.StatusRunning, service.StatusReconnecting:
+		item.switchWidget.Value = true
+	case service.StatusFailed:
+		item.switchWidget.Value = false
+	case service.StatusStopped:
+		if item.lastStatus == service.StatusRunning || item.lastStatus == service.StatusReconnecting {
+			item.switchWidget.Value = false
+		}
+	}
+	item.lastStatus = status
+}
+
+func (s *Sidebar) tunnelError(item *SidebarItem) string {
+	failure, err := s.tunnelManager.Failure(s.window.ctx, item.config.Identifier)
+	if err != nil || failure == nil {
+		return ""
+	}
+
+	switch failure.Reason {
+	case service.ReasonAuthFailed:
+		return "认证失败，请检查用户名、密码或私钥"
+	case service.ReasonHostUnreachable:
+		return "无法连接主机，请检查地址和网络"
+	case service.ReasonHostKeyMismatch:
+		return "主机密钥已变更，已拒绝连接"
+	case service.ReasonHostKeyRejected:
+		return "主机密钥未被信任"
+	case service.ReasonPortInUse:
+		return "监听端口已被占用，请更换端口"
+	case service.ReasonSecretUnavailable:
+		return "无法读取凭据，请检查主密码是否已解锁或凭据来源是否可用"
+	case service.ReasonConnectionLost:
+		return "连接已断开，请重新启动"
+	default:
+		return fmt.Sprintf("启动失败：%s", failure.Err.Error())
+	}
+}
+
 func (s *Sidebar) Layout() layout.Dimensions {
 	th := s.window.th
 	gtx := s.window.gtx
@@ -143,6 +289,17 @@ func (s *Sidebar) Layout() layout.Dimensions {
 						}
 					}
 
+					status, _ := s.tunnelManager.StatusTunnel(s.window.ctx, item.config.Identifier)
+					s.syncSwitch(item, status)
+
+					statusMsg, statusColor := s.tunnelError(item), color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+					if statusMsg == "" {
+						statusMsg, statusColor = s.tunnelAddr(item), color.NRGBA{R: 120, G: 120, B: 120, A: 255}
+					}
+					if status == service.StatusReconnecting {
+						statusMsg, statusColor = "连接中断，正在重连…", color.NRGBA{R: 230, G: 140, B: 0, A: 255}
+					}
+
 					content := func(gtx layout.Context) layout.Dimensions {
 						return layout.Stack{}.Layout(gtx,
 							layout.Expanded(func(gtx layout.Context) layout.Dimensions {
@@ -152,10 +309,26 @@ func (s *Sidebar) Layout() layout.Dimensions {
 							layout.Stacked(func(gtx layout.Context) layout.Dimensions {
 								return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceAround}.Layout(gtx,
 									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-										gtx.Constraints = layout.Exact(image.Pt(210, 30))
-										return layout.UniformInset(5).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-											return material.Body1(th, item.config.ConfigName).Layout(gtx)
-										})
+										return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+											layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+												gtx.Constraints = layout.Exact(image.Pt(210, 30))
+												return layout.UniformInset(5).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+													return material.Body1(th, item.config.ConfigName).Layout(gtx)
+												})
+											}),
+											layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+												if statusMsg == "" {
+													return layout.Dimensions{}
+												}
+												gtx.Constraints.Max.X = 210
+												return layout.Inset{Left: 5, Right: 5, Bottom: 5}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+													t := material.Caption(th, statusMsg)
+													t.Color = statusColor
+													t.MaxLines = 2
+													return t.Layout(gtx)
+												})
+											}),
+										)
 									}),
 									layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
 										if item.switchWidget.Update(gtx) {
@@ -2,11 +2,19 @@ package views
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"gioui.org/app"
+	"gioui.org/io/system"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/unit"
 	"gioui.org/widget/material"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"xtunnel/service"
 )
 
 type Window struct {
@@ -17,6 +25,12 @@ type Window struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	ui     *UI
+
+	dialog    *Dialog
+	dialogMu  sync.Mutex
+	mutex     sync.Mutex
+	reload    atomic.Bool
+	unlocking atomic.Bool
 }
 
 type UI struct {
@@ -50,6 +64,7 @@ func (w *Window) RegisterUI() {
 }
 
 func (w *Window) Run() {
+	go w.unlockOnStart(w.ctx)
 	go func() {
 		for {
 			switch e := w.window.Event().(type) {
@@ -57,6 +72,9 @@ func (w *Window) Run() {
 				w.cancel()
 				return
 			case app.FrameEvent:
+				if w.reload.Swap(false) {
+					w.ui.sidebar.reload(w.ctx)
+				}
 				w.gtx = app.NewContext(w.ops, e)
 				layout.Stack{}.Layout(w.gtx,
 					layout.Expanded(func(gtx layout.Context) layout.Dimensions {
@@ -74,6 +92,7 @@ func (w *Window) Run() {
 							)
 						})
 					}),
+					layout.Expanded(w.layoutDialog),
 				)
 				e.Frame(w.gtx.Ops)
 			}
@@ -82,6 +101,94 @@ func (w *Window) Run() {
 	app.Main()
 }
 
+func (w *Window) Manager() *service.TunnelManager {
+	return w.ui.sidebar.tunnelManager
+}
+
+// Reload syncs the tunnels with the config store right away, the sidebar follows on the next frame
+func (w *Window) Reload(ctx context.Context) error {
+	files, err := service.DefaultConfigStore().Load(ctx)
+	// the sidebar reports the corrupt files when it reloads
+	var loadErr *service.ConfigLoadError
+	if err != nil && !errors.As(err, &loadErr) {
+		return err
+	}
+
+	w.Manager().SyncConfigs(ctx, files)
+	w.reload.Store(true)
+	w.window.Invalidate()
+	return nil
+}
+
+// unlockOnStart asks for the master passphrase when there are stored credentials or plaintext ones to move into the store
+func (w *Window) unlockOnStart(ctx context.Context) {
+	if !service.Secrets.Locked() {
+		return
+	}
+	if !service.Secrets.Exists(ctx) {
+		files, _ := service.DefaultConfigStore().Load(ctx)
+		if !slices.ContainsFunc(files, (*service.ConfigFile).HasPlaintextSecrets) {
+			return
+		}
+	}
+
+	w.UnlockSecrets(ctx)
+}
+
+// UnlockSecrets asks for the master passphrase until the secret store opens or the user gives up,
+// the store is set up with it when there is none yet. It must not be called from the frame loop.
+func (w *Window) UnlockSecrets(ctx context.Context) bool {
+	if w.unlocking.Swap(true) {
+		return false
+	}
+	defer w.unlocking.Store(false)
+
+	exists := service.Secrets.Exists(ctx)
+	title, message := "解锁凭据", "请输入主密码，解锁已加密保存的密码等凭据。"
+	if !exists {
+		title, message = "设置主密码", "密码等凭据将加密保存，请设置用于保护它们的主密码。"
+	}
+
+	for service.Secrets.Locked() {
+		passphrase, ok := w.Prompt(ctx, title, message, "确定", "取消")
+		if !ok {
+			return false
+		}
+
+		if !exists {
+			repeat, ok := w.Prompt(ctx, title, "请再次输入主密码。", "确定", "取消")
+			if !ok {
+				return false
+			}
+			if repeat != passphrase {
+				message = "两次输入的主密码不一致，请重新设置。"
+				continue
+			}
+		}
+
+		err := service.Secrets.Unlock(ctx, passphrase)
+		switch {
+		case err == nil:
+		case errors.Is(err, service.ErrWrongPassphrase):
+			message = "主密码错误，请重新输入。"
+		default:
+			log.Printf("unlock secrets err: %s", err.Error())
+			message = fmt.Sprintf("解锁失败：%s", err.Error())
+		}
+	}
+
+	// plaintext credentials are moved into the store and the tunnels waiting for it are started
+	if err := w.Reload(ctx); err != nil {
+		log.Printf("reload after unlock err: %s", err.Error())
+	}
+	return true
+}
+
+// Activate brings the window to the front when xtunnel is launched again
+func (w *Window) Activate() {
+	w.window.Perform(system.ActionRaise)
+}
+
 func (w *Window) Destroy(ctx context.Context) {
 	w.ui.sidebar.tunnelManager.StopAll(ctx)
 }